@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSHAgent is a connection to an SSH agent. It stays open so that later
+// reconnections can sign again.
+type SSHAgent struct {
+	socket string
+	client agent.ExtendedAgent
+}
+
+// NewSSHAgent connects to the SSH agent listening on socket. If socket is
+// empty, SSH_AUTH_SOCK is used.
+func NewSSHAgent(socket string) (*SSHAgent, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return nil, fmt.Errorf("no SSH agent socket: SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to the SSH agent at %s: %s", socket, err.Error())
+	}
+	return &SSHAgent{socket: socket, client: agent.NewClient(conn)}, nil
+}
+
+// Signers returns a signer for every identity the agent holds.
+func (a *SSHAgent) Signers() ([]ssh.Signer, error) {
+	return a.client.Signers()
+}
+
+// Empty tells whether the agent holds no identity.
+func (a *SSHAgent) Empty() (bool, error) {
+	keys, err := a.client.List()
+	if err != nil {
+		return false, fmt.Errorf("couldn't list SSH agent identities: %s", err.Error())
+	}
+	return len(keys) == 0, nil
+}
+
+// sshAgents connects once to each SSH agent socket, sharing the connection
+// between the hosts authenticating with it.
+type sshAgents struct {
+	mu     sync.Mutex
+	agents map[string]*SSHAgent
+}
+
+// Get returns the agent listening on socket, or SSH_AUTH_SOCK if socket is
+// empty, connecting on first use. Failures aren't kept.
+func (a *sshAgents) Get(socket string) (*SSHAgent, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if sshAgent, ok := a.agents[socket]; ok {
+		return sshAgent, nil
+	}
+	sshAgent, err := NewSSHAgent(socket)
+	if err != nil {
+		return nil, err
+	}
+	if a.agents == nil {
+		a.agents = map[string]*SSHAgent{}
+	}
+	a.agents[socket] = sshAgent
+	return sshAgent, nil
+}
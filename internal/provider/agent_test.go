@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// _agent serves an in-process keyring holding keys on a unix socket, and
+// returns the socket path.
+func _agent(t *testing.T, keys ...ed25519.PrivateKey) string {
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	return socket
+}
+
+func TestAgentAuth(t *testing.T) {
+	key := _privateKey(t)
+	publicKey, _ := ssh.NewPublicKey(key.Public())
+	host := _sshServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(pubKey.Marshal(), publicKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key")
+		},
+	})
+
+	sshAgent, err := NewSSHAgent(_agent(t, _privateKey(t), key))
+	if err != nil {
+		t.Fatalf("Couldn't connect to agent: %s", err)
+	}
+
+	clientConfig := ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(sshAgent.Signers)},
+	}
+	client, err := NewRemoteClient(host, &clientConfig, false, 1)
+	if err != nil {
+		t.Fatalf("Couldn't authenticate with agent identity: %s", err)
+	}
+	client.Close()
+}
+
+func TestAgentShared(t *testing.T) {
+	socket := _agent(t, _privateKey(t))
+	var agents sshAgents
+	first, err := agents.Get(socket)
+	if err != nil {
+		t.Fatalf("Couldn't connect to agent: %s", err)
+	}
+	if second, err := agents.Get(socket); err != nil || second != first {
+		t.Errorf("The agent was connected again: %v", err)
+	}
+	if _, err := agents.Get(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Errorf("Didn't fail on a missing agent")
+	}
+}
+
+func TestAgentEmpty(t *testing.T) {
+	creds := sshCredentials{
+		Username:    types.StringValue("root"),
+		Agent:       types.BoolValue(true),
+		AgentSocket: types.StringValue(_agent(t)),
+	}
+
+	// The empty agent is the only authentication method
+	var diags diag.Diagnostics
+	(&hashicupsProvider{}).sshClientConfig(context.Background(), creds, &sshAgents{}, path.Empty(), &diags)
+	if !diags.HasError() {
+		t.Errorf("Didn't fail on empty agent")
+	}
+
+	// The password is tried instead
+	creds.Password = types.StringValue("secret")
+	diags = nil
+	(&hashicupsProvider{}).sshClientConfig(context.Background(), creds, &sshAgents{}, path.Empty(), &diags)
+	if diags.HasError() || diags.WarningsCount() != 1 {
+		t.Errorf("Expected a warning for an empty agent: %v", diags)
+	}
+}
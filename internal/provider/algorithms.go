@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHAlgorithms overrides the algorithms offered during the SSH handshake.
+// Nil lists keep the ssh package defaults.
+type SSHAlgorithms struct {
+	Ciphers           []string
+	KeyExchanges      []string
+	MACs              []string
+	HostKeyAlgorithms []string
+}
+
+// Apply sets the algorithms on clientConfig.
+func (a SSHAlgorithms) Apply(clientConfig *ssh.ClientConfig) {
+	clientConfig.Ciphers = a.Ciphers
+	clientConfig.KeyExchanges = a.KeyExchanges
+	clientConfig.MACs = a.MACs
+	clientConfig.HostKeyAlgorithms = a.HostKeyAlgorithms
+}
+
+// supportedAlgorithms lists, by provider attribute, the algorithms the ssh
+// package implements at the version pinned in go.mod. It doesn't export them,
+// and silently drops the ciphers it doesn't know.
+var supportedAlgorithms = map[string][]string{
+	"ciphers": {
+		"aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com", "arcfour256", "arcfour128", "arcfour", "aes128-cbc", "3des-cbc",
+	},
+	"key_exchanges": {
+		"curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+	},
+	"macs": {
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	},
+	"host_key_algorithms": {
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoED25519,
+	},
+}
+
+// SupportedAlgorithm tells whether the ssh package implements name, one of
+// the algorithms of attribute.
+func SupportedAlgorithm(attribute string, name string) bool {
+	for _, algorithm := range supportedAlgorithms[attribute] {
+		if name == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiationError is a SSH handshake failure for lack of a common algorithm.
+type NegotiationError struct {
+	// What is the negotiated algorithm kind, e.g. `key exchange` or
+	// `client to server cipher`.
+	What          string
+	ClientOffered []string
+	ServerOffered []string
+}
+
+var negotiationErrorPattern = regexp.MustCompile(`no common algorithm for ([^;]+); client offered: \[([^\]]*)\], server offered: \[([^\]]*)\]`)
+
+// ParseNegotiationError extracts the negotiation failure from err, or returns
+// nil if err is about something else. The ssh package only reports it as a
+// message.
+func ParseNegotiationError(err error) *NegotiationError {
+	if err == nil {
+		return nil
+	}
+	match := negotiationErrorPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil
+	}
+	return &NegotiationError{
+		What:          match[1],
+		ClientOffered: strings.Fields(match[2]),
+		ServerOffered: strings.Fields(match[3]),
+	}
+}
+
+// Attribute returns the provider attribute setting the algorithms of that
+// kind, or an empty string if there is none, e.g. for compression.
+func (e *NegotiationError) Attribute() string {
+	switch {
+	case e.What == "key exchange":
+		return "key_exchanges"
+	case e.What == "host key":
+		return "host_key_algorithms"
+	case strings.HasSuffix(e.What, "cipher"):
+		return "ciphers"
+	case strings.HasSuffix(e.What, "MAC"):
+		return "macs"
+	default:
+		return ""
+	}
+}
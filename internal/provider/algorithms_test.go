@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func _cipherServer(t *testing.T, ciphers ...string) string {
+	config := &ssh.ServerConfig{
+		Config: ssh.Config{Ciphers: ciphers},
+		PasswordCallback: func(conn ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	return _sshServer(t, config)
+}
+
+func TestNegotiationFailure(t *testing.T) {
+	host := _cipherServer(t, "aes256-ctr")
+
+	clientConfig := _passwordConfig("secret")
+	SSHAlgorithms{Ciphers: []string{"aes128-ctr"}}.Apply(clientConfig)
+	_, err := NewRemoteClient(host, clientConfig, false, 1)
+
+	negotiation := ParseNegotiationError(err)
+	if negotiation == nil {
+		t.Fatalf("Expected a negotiation error: %v", err)
+	}
+	if negotiation.Attribute() != "ciphers" {
+		t.Errorf("Unexpected attribute for %q: %s", negotiation.What, negotiation.Attribute())
+	}
+	if strings.Join(negotiation.ServerOffered, ",") != "aes256-ctr" || strings.Join(negotiation.ClientOffered, ",") != "aes128-ctr" {
+		t.Errorf("Unexpected offers: %v, %v", negotiation.ServerOffered, negotiation.ClientOffered)
+	}
+
+	SSHAlgorithms{Ciphers: []string{"aes128-ctr", "aes256-ctr"}}.Apply(clientConfig)
+	client, err := NewRemoteClient(host, clientConfig, false, 1)
+	if err != nil {
+		t.Fatalf("Couldn't connect with a common cipher: %s", err)
+	}
+	client.Close()
+}
+
+func TestNegotiationFailureDiagnostic(t *testing.T) {
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 { return 0 })
+	clients := _remoteClients()
+	clients.algorithms = SSHAlgorithms{KeyExchanges: []string{"diffie-hellman-group1-sha1"}}
+
+	connection := _connection(host, "secret")
+	var diags diag.Diagnostics
+	clients.connect(context.Background(), host, connection.credentials(), connection.HostKeyFingerprints, path.Empty(), &diags)
+	if !diags.HasError() || diags[0].Summary() != "SSH algorithm negotiation failed" {
+		t.Fatalf("Expected a negotiation diagnostic: %v", diags)
+	}
+	if detail := diags[0].Detail(); !strings.Contains(detail, "Server offered: ") || !strings.Contains(detail, "`key_exchanges`") {
+		t.Errorf("Diagnostic doesn't list the server algorithms: %s", detail)
+	}
+}
+
+func TestParseNegotiationErrorOther(t *testing.T) {
+	if ParseNegotiationError(errors.New("ssh: handshake failed: EOF")) != nil {
+		t.Errorf("Unrelated error parsed as a negotiation failure")
+	}
+}
+
+func TestSupportedCiphers(t *testing.T) {
+	// The ssh package drops the ciphers it doesn't implement
+	config := ssh.Config{Ciphers: supportedAlgorithms["ciphers"]}
+	config.SetDefaults()
+	if strings.Join(config.Ciphers, ",") != strings.Join(supportedAlgorithms["ciphers"], ",") {
+		t.Errorf("Unsupported ciphers listed: %v", supportedAlgorithms["ciphers"])
+	}
+	if SupportedAlgorithm("ciphers", "aes256-cbc") || !SupportedAlgorithm("ciphers", "aes128-cbc") {
+		t.Errorf("Unexpected support of CBC ciphers")
+	}
+}
+
+func TestUnsupportedAlgorithmDiagnostic(t *testing.T) {
+	clients := _remoteClients()
+	clients.config.Ciphers = types.ListValueMust(types.StringType, []attr.Value{
+		types.StringValue("aes128-ctr"),
+		types.StringValue("aes256-cbc"),
+	})
+	clients.config.MACs = types.ListValueMust(types.StringType, []attr.Value{types.StringValue("hmac-md5")})
+
+	var diags diag.Diagnostics
+	if clients.Get(context.Background(), nil, path.Empty(), &diags) != nil || diags.ErrorsCount() != 2 {
+		t.Fatalf("Expected two diagnostics: %v", diags)
+	}
+	for i, expected := range []path.Path{path.Root("ciphers").AtListIndex(1), path.Root("macs").AtListIndex(0)} {
+		withPath, ok := diags[i].(diag.DiagnosticWithPath)
+		if !ok || !withPath.Path().Equal(expected) || diags[i].Summary() != "Unsupported SSH algorithm" {
+			t.Errorf("Unexpected diagnostic: %v", diags[i])
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Privilege escalation methods.
+const (
+	BecomeSudo = "sudo"
+	BecomeDoas = "doas"
+	BecomeSu   = "su"
+)
+
+// Become escalates the privileges of remote commands, like Ansible's become.
+type Become struct {
+	Method string
+
+	// User to run commands as. Empty means root.
+	User string
+
+	// Password answers the password prompt, if any. Without it, commands fail
+	// instead of hanging when a password is required.
+	Password string
+
+	// RequireTTY runs sudo in a pseudo terminal, for hosts configured with
+	// `requiretty`. doas and su always read passwords from a terminal.
+	RequireTTY bool
+}
+
+// NewBecome returns the escalation of the legacy `sudo` flag, nil if unset.
+func NewBecome(sudo bool) *Become {
+	if !sudo {
+		return nil
+	}
+	return &Become{Method: BecomeSudo}
+}
+
+// usePTY tells whether commands need a pseudo terminal.
+func (b *Become) usePTY() bool {
+	return b.RequireTTY || (b.Password != "" && b.Method != BecomeSudo)
+}
+
+// command wraps script to run it as the become user. prompt is the password
+// prompt to use with sudo.
+func (b *Become) command(script string, prompt string) string {
+	user := b.User
+	if user == "" {
+		user = "root"
+	}
+	interactive := b.Password != "" || b.usePTY()
+
+	switch b.Method {
+	case BecomeDoas:
+		flags := "-n "
+		if interactive {
+			flags = ""
+		}
+		return fmt.Sprintf("doas %s-u %s sh -c %s", flags, shellQuote(user), shellQuote(script))
+	case BecomeSu:
+		return fmt.Sprintf("su %s -c %s", shellQuote(user), shellQuote(script))
+	default:
+		flags := "-n "
+		if interactive {
+			flags = fmt.Sprintf("-p %s ", shellQuote(prompt))
+			if !b.usePTY() {
+				flags = "-S " + flags
+			}
+		}
+		return fmt.Sprintf("sudo %s-u %s -- sh -c %s", flags, shellQuote(user), shellQuote(script))
+	}
+}
+
+// run runs script in session as the become user, the escalation command being
+// passed through wrap. stdin, if not nil, is fed to script once privileges are
+// gained, so that it can't be mistaken for the password.
+func (b *Become) run(session *ssh.Session, script string, wrap func(cmd string) string, stdin []byte, stdout io.Writer, stderr io.Writer) error {
+	if b.Password == "" && !b.usePTY() {
+		if stdin != nil {
+			session.Stdin = bytes.NewReader(stdin)
+		}
+		session.Stdout = stdout
+		session.Stderr = stderr
+		return session.Run(wrap(b.command(script, "")))
+	}
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ready := "BECOME-READY-" + hex.EncodeToString(nonce)
+	prompt := "BECOME-PASSWORD-" + hex.EncodeToString(nonce) + ": "
+
+	pty := b.usePTY()
+	if pty && stdin != nil {
+		// Terminals aren't binary safe, and need ^D to signal the end of input
+		script = fmt.Sprintf("base64 -d | (%s)", script)
+	}
+	script = fmt.Sprintf("echo %s >&2; %s", ready, script)
+
+	in, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	promptPattern := regexp.MustCompile(regexp.QuoteMeta(prompt))
+	if b.Method != BecomeSudo {
+		promptPattern = regexp.MustCompile(`(?i)password[^\n]*:\s*$`)
+	}
+	var rejected bool
+	output := &becomeOutput{
+		marker: []byte(ready),
+		prompt: promptPattern,
+		ready:  make(chan struct{}),
+	}
+	output.answer = func(count int) {
+		if count > 1 || b.Password == "" {
+			// Give up instead of waiting for another attempt
+			rejected = true
+			session.Close()
+			return
+		}
+		in.Write([]byte(b.Password + "\n"))
+	}
+
+	if pty {
+		modes := ssh.TerminalModes{ssh.ECHO: 0, ssh.OPOST: 0}
+		if err := session.RequestPty("xterm", 40, 80, modes); err != nil {
+			return fmt.Errorf("couldn't allocate a pseudo terminal: %s", err.Error())
+		}
+		// The terminal merges stderr into stdout
+		output.w = stdout
+		session.Stdout = output
+		session.Stderr = stderr
+	} else {
+		output.w = stderr
+		session.Stdout = stdout
+		session.Stderr = output
+	}
+
+	if err := session.Start(wrap(b.command(script, prompt))); err != nil {
+		return err
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		defer in.Close()
+		select {
+		case <-output.ready:
+		case <-finished:
+			return
+		}
+		if stdin == nil {
+			return
+		}
+		if !pty {
+			in.Write(stdin)
+			return
+		}
+		encoded := base64.StdEncoding.EncodeToString(stdin)
+		for len(encoded) > 0 {
+			n := 76
+			if n > len(encoded) {
+				n = len(encoded)
+			}
+			if _, err := in.Write([]byte(encoded[:n] + "\n")); err != nil {
+				return
+			}
+			encoded = encoded[n:]
+		}
+		in.Write([]byte{4})
+	}()
+
+	err = session.Wait()
+	close(finished)
+
+	// Output before the marker explains why privileges weren't gained
+	if pending := output.Pending(); pending != nil {
+		stderr.Write(pending)
+	}
+	if rejected {
+		return fmt.Errorf("%s password was rejected or is missing", b.Method)
+	}
+	return err
+}
+
+// becomeOutput scans the output of an escalated command, answering password
+// prompts, until the ready marker. What follows is the command output, written
+// to w.
+type becomeOutput struct {
+	w      io.Writer
+	marker []byte
+	prompt *regexp.Regexp
+
+	// answer is called on each prompt, with the prompt count.
+	answer func(count int)
+	ready  chan struct{}
+
+	mu      sync.Mutex
+	pending []byte
+	prompts int
+	done    bool
+}
+
+func (o *becomeOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done {
+		return o.w.Write(p)
+	}
+
+	o.pending = append(o.pending, p...)
+	if i := bytes.Index(o.pending, o.marker); i >= 0 {
+		rest := bytes.TrimLeft(o.pending[i+len(o.marker):], "\r")
+		rest = bytes.TrimPrefix(rest, []byte("\n"))
+		o.done = true
+		o.pending = nil
+		close(o.ready)
+		if len(rest) > 0 {
+			if _, err := o.w.Write(rest); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+
+	if o.prompt.Match(o.pending) {
+		o.pending = o.pending[:0]
+		o.prompts++
+		o.answer(o.prompts)
+	}
+	return len(p), nil
+}
+
+// Pending returns the output received before the ready marker, if it wasn't
+// received.
+func (o *becomeOutput) Pending() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done {
+		return nil
+	}
+	return o.pending
+}
+
+// shellQuote quotes s as a single shell word. Backslashes are escaped out of
+// the quotes too, as fish interprets them in single quotes.
+func shellQuote(s string) string {
+	return "'" + strings.NewReplacer(`'`, `'\''`, `\`, `'\\'`).Replace(s) + "'"
+}
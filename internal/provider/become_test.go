@@ -0,0 +1,195 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// _escalation records what an emulated escalation command received.
+type _escalation struct {
+	mu      sync.Mutex
+	cmd     string
+	content []byte
+}
+
+var (
+	_sudoPromptPattern = regexp.MustCompile(`-p '([^']*)'`)
+	_readyPattern      = regexp.MustCompile(`BECOME-READY-[0-9a-f]+`)
+)
+
+// _escalationServer starts a SSH server emulating sudo or doas, expecting
+// password, or none if empty. With terminal, prompts and stdin go through the
+// pseudo terminal, like doas does.
+func _escalationServer(t *testing.T, password string, terminal bool) (string, *_escalation) {
+	received := &_escalation{}
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		received.mu.Lock()
+		defer received.mu.Unlock()
+		received.cmd = cmd
+
+		reader := bufio.NewReader(stdin)
+		prompts := stderr
+		if terminal {
+			prompts = stdout
+		}
+		if password != "" {
+			prompt := "doas (deploy@host) password: "
+			if match := _sudoPromptPattern.FindStringSubmatch(cmd); match != nil {
+				prompt = match[1]
+			}
+			for attempt := 0; ; attempt++ {
+				fmt.Fprint(prompts, prompt)
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return 1
+				}
+				if strings.TrimSuffix(line, "\n") == password {
+					break
+				}
+				fmt.Fprintln(prompts, "Sorry, try again.")
+			}
+		}
+
+		if marker := _readyPattern.FindString(cmd); marker != "" {
+			fmt.Fprintln(prompts, marker)
+		}
+		if terminal {
+			encoded, _ := reader.ReadString(4)
+			received.content, _ = base64.StdEncoding.DecodeString(strings.NewReplacer("\n", "", "\x04", "").Replace(encoded))
+		} else {
+			received.content, _ = io.ReadAll(reader)
+		}
+		fmt.Fprint(stdout, "output")
+		return 0
+	})
+	return host, received
+}
+
+func _becomeClient(t *testing.T, host string, become *Become) *RemoteClient {
+	client, err := NewRemoteClientWithDialer(context.Background(), &Dialer{Host: host, ClientConfig: _passwordConfig("secret")}, become, 5)
+	if err != nil {
+		t.Fatalf("Couldn't connect: %s", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestBecomeSudoPassword(t *testing.T) {
+	host, received := _escalationServer(t, "blabetiblou", false)
+	client := _becomeClient(t, host, &Become{Method: BecomeSudo, Password: "blabetiblou"})
+
+	if err := client.WriteFile(context.Background(), "content", "/etc/file", true, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if string(received.content) != "content" {
+		t.Errorf("Unexpected content written: %q", received.content)
+	}
+	if !strings.HasPrefix(received.cmd, "sudo -S -p ") || !strings.Contains(received.cmd, "-u 'root' -- sh -c ") {
+		t.Errorf("Unexpected command: %s", received.cmd)
+	}
+
+	content, _, err := client.ReadFile(context.Background(), "/etc/file", true)
+	if err != nil || content != "output" {
+		t.Errorf("Unexpected read: %q, %v", content, err)
+	}
+}
+
+func TestBecomeSudoWrongPassword(t *testing.T) {
+	host, _ := _escalationServer(t, "blabetiblou", false)
+	client := _becomeClient(t, host, &Become{Method: BecomeSudo, Password: "wrong"})
+
+	_, _, err := client.ReadFile(context.Background(), "/etc/file", true)
+	if err == nil || !strings.Contains(err.Error(), "sudo password was rejected") {
+		t.Errorf("Expected a rejected password: %v", err)
+	}
+}
+
+func TestBecomeWithoutPassword(t *testing.T) {
+	host, received := _escalationServer(t, "", false)
+	client := _becomeClient(t, host, NewBecome(true))
+
+	if err := client.WriteFile(context.Background(), "content", "/etc/file", true, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if string(received.content) != "content" || !strings.HasPrefix(received.cmd, "sudo -n -u 'root' -- sh -c ") {
+		t.Errorf("Unexpected command %q with content %q", received.cmd, received.content)
+	}
+}
+
+func TestBecomeDoasTerminal(t *testing.T) {
+	host, received := _escalationServer(t, "blabetiblou", true)
+	client := _becomeClient(t, host, &Become{Method: BecomeDoas, User: "deploy", Password: "blabetiblou"})
+
+	content := "binary\x00\x04\r\ncontent"
+	if err := client.WriteFile(context.Background(), content, "/etc/file", true, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if !bytes.Equal(received.content, []byte(content)) {
+		t.Errorf("Unexpected content written: %q", received.content)
+	}
+	if !strings.HasPrefix(received.cmd, "doas -u 'deploy' sh -c ") {
+		t.Errorf("Unexpected command: %s", received.cmd)
+	}
+}
+
+func TestBecomeCommandQuoting(t *testing.T) {
+	become := &Become{Method: BecomeSu, User: "o'brien"}
+	command := become.command("echo 'hi'", "")
+	if command != `su 'o'\''brien' -c 'echo '\''hi'\'''` {
+		t.Errorf("Unexpected command: %s", command)
+	}
+}
+
+func TestRunAs(t *testing.T) {
+	host, received := _escalationServer(t, "", false)
+	client := _becomeClient(t, host, nil)
+
+	if err := client.WriteFile(context.Background(), "content", "/srv/file", false, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if strings.Contains(received.cmd, "sudo") {
+		t.Errorf("Unexpected escalation: %s", received.cmd)
+	}
+
+	if err := client.RunAs("app").WriteFile(context.Background(), "content", "/srv/file", true, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if !strings.HasPrefix(received.cmd, "sudo -n -u 'app' -- sh -c ") {
+		t.Errorf("Unexpected command: %s", received.cmd)
+	}
+	if client.Sudo() {
+		t.Errorf("RunAs changed the original client")
+	}
+}
+
+func TestResourceClient(t *testing.T) {
+	client := &RemoteClient{become: NewBecome(true)}
+
+	if _, sudo := resourceClient(context.Background(), client, commandSettings{Sudo: types.BoolNull(), RunAs: types.StringNull()}, &diag.Diagnostics{}); !sudo {
+		t.Errorf("Expected the provider default")
+	}
+	if _, sudo := resourceClient(context.Background(), client, commandSettings{Sudo: types.BoolValue(false), RunAs: types.StringNull()}, &diag.Diagnostics{}); sudo {
+		t.Errorf("Expected sudo to be disabled")
+	}
+	runAs, sudo := resourceClient(context.Background(), client, commandSettings{Sudo: types.BoolNull(), RunAs: types.StringValue("app")}, &diag.Diagnostics{})
+	if !sudo || runAs.become.User != "app" {
+		t.Errorf("Expected to run as app")
+	}
+
+	var diags diag.Diagnostics
+	resourceClient(context.Background(), client, commandSettings{Sudo: types.BoolValue(false), RunAs: types.StringValue("app")}, &diags)
+	if !diags.HasError() {
+		t.Errorf("Expected run_as to conflict with sudo = false")
+	}
+}
@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	umaskPattern   = regexp.MustCompile(`^[0-7]{3,4}$`)
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// CommandEnv is the environment remote commands run in. The zero value runs
+// them as is, in the login shell of the user.
+type CommandEnv struct {
+	// Shell interprets commands, with `-c`, instead of the login shell, e.g.
+	// `sh` for users with fish or a restricted shell.
+	Shell string
+
+	// Environment variables, sent with the session if the server accepts them,
+	// exported by the command otherwise.
+	Environment map[string]string
+
+	// Umask of the files created by commands, in octal, e.g. `022`.
+	Umask string
+
+	// WorkingDir is the directory commands run in.
+	WorkingDir string
+}
+
+// Merge returns e overridden by the settings of other. Environment variables
+// are merged.
+func (e CommandEnv) Merge(other CommandEnv) CommandEnv {
+	merged := e
+	if other.Shell != "" {
+		merged.Shell = other.Shell
+	}
+	if other.Umask != "" {
+		merged.Umask = other.Umask
+	}
+	if other.WorkingDir != "" {
+		merged.WorkingDir = other.WorkingDir
+	}
+	if len(other.Environment) > 0 {
+		merged.Environment = make(map[string]string, len(e.Environment)+len(other.Environment))
+		for name, value := range e.Environment {
+			merged.Environment[name] = value
+		}
+		for name, value := range other.Environment {
+			merged.Environment[name] = value
+		}
+	}
+	return merged
+}
+
+// Validate checks the umask and the environment variable names, returning the
+// first invalid setting, e.g. `umask`, and why.
+func (e CommandEnv) Validate() (string, error) {
+	if e.Umask != "" && !umaskPattern.MatchString(e.Umask) {
+		return "umask", fmt.Errorf("expected an octal umask such as `022`, got %q", e.Umask)
+	}
+	for name := range e.Environment {
+		if !envNamePattern.MatchString(name) {
+			return "environment", fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return "", nil
+}
+
+// script prefixes cmd with the settings to apply in the remote shell. The
+// environment variables are set on session when the server accepts them,
+// unless sudo is set, as sudo resets the environment, or session is nil.
+func (e CommandEnv) script(session *ssh.Session, cmd string, sudo bool) string {
+	var prefix []string
+	if e.WorkingDir != "" {
+		prefix = append(prefix, fmt.Sprintf("cd %s || exit", shellQuote(e.WorkingDir)))
+	}
+	if e.Umask != "" {
+		prefix = append(prefix, fmt.Sprintf("umask %s || exit", e.Umask))
+	}
+
+	names := make([]string, 0, len(e.Environment))
+	for name := range e.Environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var exports []string
+	for _, name := range names {
+		value := e.Environment[name]
+		if !sudo && session != nil && session.Setenv(name, value) == nil {
+			continue
+		}
+		exports = append(exports, fmt.Sprintf("%s=%s", name, shellQuote(value)))
+	}
+	if len(exports) > 0 {
+		prefix = append(prefix, "export "+strings.Join(exports, " "))
+	}
+
+	if len(prefix) == 0 {
+		return cmd
+	}
+	return strings.Join(append(prefix, cmd), "; ")
+}
+
+// wrap returns the command to send to the server to run cmd with Shell.
+func (e CommandEnv) wrap(cmd string) string {
+	if e.Shell == "" {
+		return cmd
+	}
+	return fmt.Sprintf("%s -c %s", e.Shell, shellQuote(cmd))
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCommandEnvScript(t *testing.T) {
+	var mu sync.Mutex
+	var received string
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		received = cmd
+		return 0
+	})
+	client := _remoteClient(t, host).WithEnv(CommandEnv{
+		Shell:       "sh",
+		Environment: map[string]string{"LANG": "C.UTF-8", "PATH": "/opt/bin:/usr/bin"},
+		Umask:       "022",
+		WorkingDir:  "/srv/o'app",
+	})
+
+	if _, _, err := client.ReadFile(context.Background(), "/tmp/file", false); err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	// The test server rejects environment variables, they're exported instead
+	script := `cd '/srv/o'\''app' || exit; umask 022 || exit; export LANG='C.UTF-8' PATH='/opt/bin:/usr/bin'; cat /tmp/file`
+	mu.Lock()
+	defer mu.Unlock()
+	if received != "sh -c "+shellQuote(script) {
+		t.Errorf("Unexpected command: %s", received)
+	}
+}
+
+func TestCommandEnvSudo(t *testing.T) {
+	host, received := _escalationServer(t, "", false)
+	client := _becomeClient(t, host, nil).WithEnv(CommandEnv{Shell: "sh", Umask: "027"})
+
+	if err := client.WriteFile(context.Background(), "content", "/etc/file", true, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	// sudo is run by the shell, and the settings apply to the escalated command
+	if !strings.HasPrefix(received.cmd, "sh -c 'sudo -n -u ") || !strings.Contains(received.cmd, "umask 027 || exit; cat /dev/stdin") {
+		t.Errorf("Unexpected command: %s", received.cmd)
+	}
+	if string(received.content) != "content" {
+		t.Errorf("Unexpected content written: %q", received.content)
+	}
+}
+
+func TestCommandEnvMerge(t *testing.T) {
+	provider := CommandEnv{Shell: "sh", Umask: "022", Environment: map[string]string{"LANG": "C", "PATH": "/usr/bin"}}
+	merged := provider.Merge(CommandEnv{Umask: "077", Environment: map[string]string{"LANG": "C.UTF-8"}})
+
+	if merged.Shell != "sh" || merged.Umask != "077" {
+		t.Errorf("Unexpected settings: %+v", merged)
+	}
+	if merged.Environment["LANG"] != "C.UTF-8" || merged.Environment["PATH"] != "/usr/bin" {
+		t.Errorf("Unexpected environment: %v", merged.Environment)
+	}
+	if provider.Environment["LANG"] != "C" {
+		t.Errorf("Merge changed the provider environment")
+	}
+}
+
+func TestCommandEnvValidate(t *testing.T) {
+	for _, test := range []struct {
+		env       CommandEnv
+		attribute string
+	}{
+		{CommandEnv{Umask: "0022"}, ""},
+		{CommandEnv{Umask: "u=rwx"}, "umask"},
+		{CommandEnv{Environment: map[string]string{"MY_VAR": ""}}, ""},
+		{CommandEnv{Environment: map[string]string{"MY-VAR": ""}}, "environment"},
+	} {
+		attribute, err := test.env.Validate()
+		if attribute != test.attribute || (err != nil) != (test.attribute != "") {
+			t.Errorf("Unexpected validation of %+v: %q, %v", test.env, attribute, err)
+		}
+	}
+}
+
+func TestShellQuoteBackslashes(t *testing.T) {
+	// fish interprets backslashes in single quotes, they're kept out of them
+	if quoted := shellQuote(`a\'b`); quoted != `'a'\\''\''b'` {
+		t.Errorf("Unexpected quoting: %s", quoted)
+	}
+}
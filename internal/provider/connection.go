@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Default timeouts of resource operations, bounding every command they run.
+// Reads are expected to be fast.
+const (
+	defaultCreateTimeout = 20 * time.Minute
+	defaultReadTimeout   = 5 * time.Minute
+	defaultUpdateTimeout = 20 * time.Minute
+	defaultDeleteTimeout = 20 * time.Minute
+)
+
+// connectionBlock is the schema of the resource conn block, overriding
+// the provider host and credentials.
+func connectionBlock() schema.SingleNestedBlock {
+	return schema.SingleNestedBlock{
+		Description: "Host to manage the resource on, instead of the provider one. " +
+			"The other provider settings, such as `jump_host`, `proxy` or `host_key_check`, still apply. " +
+			"Resources with the same connection share one SSH connection.",
+		Attributes: map[string]schema.Attribute{
+			"host": schema.StringAttribute{
+				Description: "Remote host to connect. example: `localhost:8022`.",
+				Required:    true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"username": schema.StringAttribute{
+				Description: "SSH user. Default is current user",
+				Optional:    true,
+			},
+			"password": schema.StringAttribute{
+				Description: "SSH password.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"private_key": schema.StringAttribute{
+				Description: "SSH private key",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"private_key_path": schema.StringAttribute{
+				Description: "Path to SSH private key",
+				Optional:    true,
+			},
+			"private_key_passphrase": schema.StringAttribute{
+				Description: "Passphrase of an encrypted private key",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"certificate_path": schema.StringAttribute{
+				Description: "Path to an OpenSSH user certificate signed for the private key",
+				Optional:    true,
+			},
+			"agent": schema.BoolAttribute{
+				Description: "Whether to authenticate with the identities of the SSH agent. Default: false",
+				Optional:    true,
+			},
+			"agent_socket": schema.StringAttribute{
+				Description: "Path to the SSH agent socket. Default is the `SSH_AUTH_SOCK` env var",
+				Optional:    true,
+			},
+			"host_key_fingerprints": schema.ListAttribute{
+				Description: "Pinned SHA256 host key fingerprints of the host",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+		},
+	}
+}
+
+// commandSettings are the resource attributes changing how the commands of the
+// resource run.
+type commandSettings struct {
+	Sudo        types.Bool
+	RunAs       types.String
+	Shell       types.String
+	Environment types.Map
+	Umask       types.String
+	WorkingDir  types.String
+}
+
+// resourceClient returns the client managing a resource, and whether its
+// commands are escalated, from the resource settings.
+func resourceClient(ctx context.Context, client *RemoteClient, settings commandSettings, diags *diag.Diagnostics) (*RemoteClient, bool) {
+	env := commandEnv(ctx, settings.Shell, settings.Environment, settings.Umask, settings.WorkingDir, path.Empty(), diags)
+	if diags.HasError() {
+		return nil, false
+	}
+	client = client.WithEnv(env)
+
+	sudo, runAs := settings.Sudo, settings.RunAs
+	if !runAs.IsNull() {
+		if !sudo.IsNull() && !sudo.ValueBool() {
+			diags.AddAttributeError(
+				path.Root("run_as"),
+				"Conflicting sudo and run_as",
+				"`run_as` runs commands through the become method, it can't be used with `sudo = false`.",
+			)
+			return nil, false
+		}
+		return client.RunAs(runAs.ValueString()), true
+	}
+	if sudo.IsNull() {
+		return client, client.Sudo()
+	}
+	return client, sudo.ValueBool()
+}
+
+// commandEnv reads the command environment attributes, under attributes.
+func commandEnv(ctx context.Context, shell types.String, environment types.Map, umask types.String, workingDir types.String, attributes path.Path, diags *diag.Diagnostics) CommandEnv {
+	env := CommandEnv{
+		Shell:      shell.ValueString(),
+		Umask:      umask.ValueString(),
+		WorkingDir: workingDir.ValueString(),
+	}
+	if !environment.IsNull() {
+		diags.Append(environment.ElementsAs(ctx, &env.Environment, false)...)
+	}
+	if attribute, err := env.Validate(); err != nil {
+		diags.AddAttributeError(attributes.AtName(attribute), "Invalid command environment", err.Error())
+	}
+	return env
+}
+
+// addCommandError adds the failure of a remote command to diags, detail being
+// followed by err. Timeouts and cancellations get a diagnostic of their own.
+func addCommandError(diags *diag.Diagnostics, summary string, detail string, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		diags.AddError(
+			"Remote command timed out",
+			summary+": the remote command didn't complete in time and was stopped. "+
+				"Increase `command_timeout`, or the resource `timeouts`, if it needs more time.\n\n"+err.Error(),
+		)
+	case errors.Is(err, context.Canceled):
+		diags.AddError(
+			"Remote command cancelled",
+			summary+": the operation was cancelled and the remote command was stopped.\n\n"+err.Error(),
+		)
+	default:
+		diags.AddError(summary, detail+err.Error())
+	}
+}
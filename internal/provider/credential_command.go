@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandCredentials are the credentials printed by a credential command, as
+// a JSON document. Every field is optional and sensitive.
+type CommandCredentials struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	PrivateKey  string `json:"private_key"`
+	Passphrase  string `json:"passphrase"`
+	Certificate string `json:"certificate"`
+}
+
+// String keeps the credentials out of logs and error messages.
+func (c CommandCredentials) String() string {
+	return "CommandCredentials(redacted)"
+}
+
+// GoString keeps the credentials out of `%#v` formatting.
+func (c CommandCredentials) GoString() string {
+	return c.String()
+}
+
+// RunCredentialCommand runs command, a program followed by its arguments, and
+// parses the credentials it prints on stdout.
+func RunCredentialCommand(ctx context.Context, command []string) (*CommandCredentials, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, errors.New("the command is empty")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return nil, fmt.Errorf("`%s` failed: %s", command[0], err.Error())
+		}
+		return nil, fmt.Errorf("`%s` failed: %s\n%s", command[0], err.Error(), message)
+	}
+
+	var credentials CommandCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		// The output isn't quoted, it may hold secrets
+		return nil, fmt.Errorf("`%s` didn't print a JSON document with credentials: %s", command[0], jsonErrorKind(err))
+	}
+	return &credentials, nil
+}
+
+// jsonErrorKind describes err without the JSON excerpts it may quote.
+func jsonErrorKind(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("invalid JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("field %q should be a %s", typeErr.Field, typeErr.Type)
+	default:
+		return "invalid JSON"
+	}
+}
@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func _script(script string) []string {
+	return []string{"sh", "-c", script}
+}
+
+func TestCredentialCommand(t *testing.T) {
+	credentials, err := RunCredentialCommand(context.Background(), _script(`echo '{"username": "deploy", "password": "blabetiblou"}'`))
+	if err != nil {
+		t.Fatalf("Credential command failed: %s", err)
+	}
+	if credentials.Username != "deploy" || credentials.Password != "blabetiblou" {
+		t.Errorf("Unexpected credentials: %q, %q", credentials.Username, credentials.Password)
+	}
+	if printed := fmt.Sprintf("%v %+v %#v", credentials, *credentials, *credentials); strings.Contains(printed, "blabetiblou") {
+		t.Errorf("Credentials leaked when printed: %s", printed)
+	}
+}
+
+func TestCredentialCommandFailure(t *testing.T) {
+	_, err := RunCredentialCommand(context.Background(), _script(`echo "vault is sealed" >&2; exit 3`))
+	if err == nil || !strings.Contains(err.Error(), "vault is sealed") {
+		t.Errorf("Expected the command stderr in the error: %v", err)
+	}
+
+	_, err = RunCredentialCommand(context.Background(), _script(`echo 'password=blabetiblou'`))
+	if err == nil {
+		t.Fatalf("Expected an error for non JSON output")
+	}
+	if strings.Contains(err.Error(), "blabetiblou") {
+		t.Errorf("Command output leaked in the error: %s", err)
+	}
+
+	if _, err := RunCredentialCommand(context.Background(), nil); err == nil {
+		t.Errorf("Expected an error for an empty command")
+	}
+}
+
+func TestCredentialCommandPrecedence(t *testing.T) {
+	command, _ := types.ListValueFrom(context.Background(), types.StringType, _script(`echo '{"username": "helper", "password": "helper"}'`))
+	config := hashicupsProviderModel{
+		CredentialCommand: command,
+		Username:          types.StringValue("explicit"),
+	}
+
+	var diags diag.Diagnostics
+	(&hashicupsProvider{}).applyCredentialCommand(context.Background(), &config, &diags)
+	if diags.HasError() {
+		t.Fatalf("Credential command failed: %v", diags)
+	}
+	if config.Username.ValueString() != "explicit" {
+		t.Errorf("Explicit username was overridden: %s", config.Username)
+	}
+	if config.Password.ValueString() != "helper" {
+		t.Errorf("Password wasn't filled from the command: %s", config.Password)
+	}
+}
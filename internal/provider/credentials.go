@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrPassphraseMissing is returned when an encrypted private key is given
+// without a passphrase.
+var ErrPassphraseMissing = errors.New("private key is encrypted but no passphrase was given")
+
+// ParsePrivateKey parses a PEM encoded private key, decrypting it with
+// passphrase if it is protected. An empty passphrase means none was given.
+func ParsePrivateKey(content []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(content, []byte(passphrase))
+	}
+
+	signer, err := ssh.ParsePrivateKey(content)
+	if err != nil {
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) {
+			return nil, ErrPassphraseMissing
+		}
+		return nil, err
+	}
+	return signer, nil
+}
+
+// privateKeyError formats a private key parsing error for diagnostics.
+func privateKeyError(err error) string {
+	if errors.Is(err, ErrPassphraseMissing) {
+		return fmt.Sprintf("%s. Set `private_key_passphrase` or `private_key_passphrase_env_var`.", err.Error())
+	}
+	return fmt.Sprintf("couldn't create a ssh client config from private key: %s", err.Error())
+}
+
+// ParseCertificate parses an OpenSSH user certificate in authorized_keys
+// format, as written by `ssh-keygen -s` in `id_*-cert.pub` files.
+func ParseCertificate(content []byte) (*ssh.Certificate, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("expected an OpenSSH certificate, got a %s public key", key.Type())
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("expected an OpenSSH user certificate, got a host certificate")
+	}
+	return cert, nil
+}
+
+// DescribeCertificate summarizes the principals and validity of cert, to help
+// understanding why it was rejected.
+func DescribeCertificate(cert *ssh.Certificate, now time.Time) string {
+	principals := "any"
+	if len(cert.ValidPrincipals) > 0 {
+		principals = strings.Join(cert.ValidPrincipals, ", ")
+	}
+
+	validity := fmt.Sprintf("from %s", time.Unix(int64(cert.ValidAfter), 0).UTC().Format(time.RFC3339))
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		validity += " forever"
+	} else {
+		validBefore := time.Unix(int64(cert.ValidBefore), 0).UTC()
+		validity += fmt.Sprintf(" to %s", validBefore.Format(time.RFC3339))
+		if !now.Before(validBefore) {
+			validity += " (expired)"
+		}
+	}
+	if now.Before(time.Unix(int64(cert.ValidAfter), 0)) {
+		validity += " (not yet valid)"
+	}
+
+	return fmt.Sprintf("certificate %q with principals [%s], valid %s", cert.KeyId, principals, validity)
+}
+
+// IsAuthError tells whether err reports that the server rejected every
+// authentication method.
+func IsAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "ssh: unable to authenticate")
+}
+
+// NewKeyboardInteractiveChallenge answers keyboard-interactive prompts with the
+// answer of the first prompt regex, in lexical order, matching them. Hidden
+// prompts matching no regex are answered with password, if not empty.
+func NewKeyboardInteractiveChallenge(answers map[string]string, password string) (ssh.KeyboardInteractiveChallenge, error) {
+	patterns := make([]string, 0, len(answers))
+	for pattern := range answers {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	regexps := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt regex %q: %s", pattern, err.Error())
+		}
+		regexps[i] = re
+	}
+
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		replies := make([]string, len(questions))
+	questions:
+		for i, question := range questions {
+			for j, re := range regexps {
+				if re.MatchString(question) {
+					replies[i] = answers[patterns[j]]
+					continue questions
+				}
+			}
+			if !echos[i] && password != "" {
+				replies[i] = password
+				continue
+			}
+			return nil, fmt.Errorf("no keyboard-interactive answer for prompt %q", question)
+		}
+		return replies, nil
+	}, nil
+}
@@ -0,0 +1,329 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+// JumpHost is a SSH server relaying the connection to the next hop, like
+// OpenSSH's ProxyJump.
+type JumpHost struct {
+	Host         string
+	ClientConfig *ssh.ClientConfig
+}
+
+// Dialer opens SSH connections to a remote host.
+type Dialer struct {
+	Host         string
+	ClientConfig *ssh.ClientConfig
+
+	// JumpHosts to tunnel the connection through, in order.
+	JumpHosts []JumpHost
+
+	// Proxy relays the TCP connection to the first hop. Nil means direct.
+	Proxy proxy.Dialer
+
+	// ConnectTimeout bounds each hop connection, handshake and
+	// authentication included. Zero means no timeout.
+	ConnectTimeout time.Duration
+
+	// ConnectRetryTimeout is how long failed connections are retried, with
+	// exponential backoff, e.g. while the remote host boots. Zero means a
+	// single attempt.
+	ConnectRetryTimeout time.Duration
+
+	// KeepAliveInterval is the interval between keepalive requests on
+	// established connections, to detect dead ones. Zero disables them.
+	KeepAliveInterval time.Duration
+}
+
+// Backoff bounds between connection attempts.
+const (
+	connectRetryMinDelay = time.Second
+	connectRetryMaxDelay = 15 * time.Second
+)
+
+// retryableError is a connection failure at the network level, e.g. a host
+// not listening yet or a connection reset, that may go away by itself.
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+	return e.err
+}
+
+// Dial connects to the remote host, retrying network failures until
+// ConnectRetryTimeout is elapsed. Host key, authentication and algorithm
+// negotiation failures are returned right away.
+func (d *Dialer) Dial(ctx context.Context) (*ssh.Client, error) {
+	deadline := time.Now().Add(d.ConnectRetryTimeout)
+	delay := connectRetryMinDelay
+
+	for attempt := 1; ; attempt++ {
+		client, err := d.dialOnce(ctx)
+		if err == nil {
+			return client, nil
+		}
+		var retryable retryableError
+		if !errors.As(err, &retryable) {
+			return nil, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 || ctx.Err() != nil {
+			if attempt == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("gave up after %d attempts in %s, last error: %s", attempt, d.ConnectRetryTimeout, err.Error())
+		}
+
+		if delay > remaining {
+			delay = remaining
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > connectRetryMaxDelay {
+			delay = connectRetryMaxDelay
+		}
+	}
+}
+
+// dialOnce connects to the remote host, tunneling the connection through each
+// jump host in order. Jump host connections are closed when the returned
+// client is.
+func (d *Dialer) dialOnce(ctx context.Context) (*ssh.Client, error) {
+	var previous *ssh.Client
+	closeChain := func() {
+		if previous != nil {
+			previous.Close()
+		}
+	}
+
+	hops := append(append([]JumpHost{}, d.JumpHosts...), JumpHost{Host: d.Host, ClientConfig: d.ClientConfig})
+	for i, jumpHost := range hops {
+		hop := fmt.Sprintf("jump host #%d (%s)", i+1, jumpHost.Host)
+		if i == len(d.JumpHosts) {
+			hop = fmt.Sprintf("remote host %s", jumpHost.Host)
+		}
+
+		var client *ssh.Client
+		var err error
+		if previous == nil {
+			client, err = d.dialFirst(ctx, jumpHost.Host, jumpHost.ClientConfig)
+		} else {
+			client, err = d.dialThrough(ctx, previous, jumpHost.Host, jumpHost.ClientConfig)
+		}
+		if err != nil {
+			closeChain()
+			hopErr := fmt.Errorf("%s: %s", hop, err.Error())
+			if previous != nil {
+				hopErr = fmt.Errorf("%s, through jump host #%d: %s", hop, i, err.Error())
+			}
+			var retryable retryableError
+			if errors.As(err, &retryable) {
+				return nil, retryableError{hopErr}
+			}
+			return nil, hopErr
+		}
+
+		if previous != nil {
+			// The tunnel is carried by the previous connection, release it
+			// once the new one is done.
+			go func(client *ssh.Client, previous *ssh.Client) {
+				client.Wait()
+				previous.Close()
+			}(client, previous)
+		}
+		previous = client
+	}
+
+	return previous, nil
+}
+
+// dialFirst opens the SSH connection to the first hop, through the proxy if any.
+func (d *Dialer) dialFirst(ctx context.Context, host string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	ctx, cancel := d.hopContext(ctx)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	switch dialer := d.Proxy.(type) {
+	case nil:
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	case proxy.ContextDialer:
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+		if err != nil {
+			err = fmt.Errorf("proxy: %s", err.Error())
+		}
+	default:
+		conn, err = dialer.Dial("tcp", host)
+		if err != nil {
+			err = fmt.Errorf("proxy: %s", err.Error())
+		}
+	}
+	if err != nil {
+		return nil, retryableError{err}
+	}
+	return d.newClient(ctx, conn, host, clientConfig)
+}
+
+// dialThrough opens a SSH connection to host over a TCP/IP channel of client.
+func (d *Dialer) dialThrough(ctx context.Context, client *ssh.Client, host string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	ctx, cancel := d.hopContext(ctx)
+	defer cancel()
+
+	// The ssh package can't cancel the opening of a channel, it's left to
+	// complete in the background and closed.
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		conn, err := client.Dial("tcp", host)
+		dialed <- dialResult{conn, err}
+	}()
+
+	select {
+	case result := <-dialed:
+		// Other rejections, e.g. forwarding prohibited, won't go away
+		var openErr *ssh.OpenChannelError
+		if errors.As(result.err, &openErr) && openErr.Reason == ssh.ConnectionFailed {
+			return nil, retryableError{result.err}
+		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		return d.newClient(ctx, result.conn, host, clientConfig)
+	case <-ctx.Done():
+		go func() {
+			if result := <-dialed; result.conn != nil {
+				result.conn.Close()
+			}
+		}()
+		return nil, retryableError{d.hopError(ctx, "tunnel opening")}
+	}
+}
+
+// hopContext bounds the connection to a hop by ConnectTimeout.
+func (d *Dialer) hopContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.ConnectTimeout > 0 {
+		return context.WithTimeout(ctx, d.ConnectTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// hopError reports step of a hop connection interrupted as ctx is done.
+func (d *Dialer) hopError(ctx context.Context, step string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && d.ConnectTimeout > 0 {
+		return fmt.Errorf("%s timed out after %s", step, d.ConnectTimeout)
+	}
+	return fmt.Errorf("%s interrupted: %w", step, ctx.Err())
+}
+
+// ioErrorConn records the first read or write error of a connection.
+type ioErrorConn struct {
+	net.Conn
+	mu  sync.Mutex
+	err error
+}
+
+func (c *ioErrorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.record(err)
+	return n, err
+}
+
+func (c *ioErrorConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.record(err)
+	return n, err
+}
+
+func (c *ioErrorConn) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// failed tells whether a read or write failed.
+func (c *ioErrorConn) failed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+// newClient runs the SSH handshake on conn, closing it if ctx is done first.
+// Handshakes failing on a connection error are retryable.
+func (d *Dialer) newClient(ctx context.Context, conn net.Conn, host string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	// The ssh package only reports handshake failures as messages
+	ioConn := &ioErrorConn{Conn: conn}
+	conn = ioConn
+	hostKeyFailed := make(chan struct{}, 1)
+	if callback := clientConfig.HostKeyCallback; callback != nil {
+		config := *clientConfig
+		config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := callback(hostname, remote, key)
+			if err != nil {
+				select {
+				case hostKeyFailed <- struct{}{}:
+				default:
+				}
+			}
+			return err
+		}
+		clientConfig = &config
+	}
+
+	// SSH channels don't support deadlines, close the connection instead
+	finished := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			interrupted <- true
+		case <-finished:
+			interrupted <- false
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, clientConfig)
+	close(finished)
+	if <-interrupted {
+		if err == nil {
+			c.Close()
+		}
+		return nil, retryableError{d.hopError(ctx, "SSH handshake")}
+	}
+	if err != nil {
+		conn.Close()
+		select {
+		case <-hostKeyFailed:
+			return nil, err
+		default:
+		}
+		if IsAuthError(err) || ParseNegotiationError(err) != nil || !ioConn.failed() {
+			return nil, err
+		}
+		return nil, retryableError{err}
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,230 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func _passwordServer(t *testing.T, password string) string {
+	return _sshServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if string(p) != password {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	})
+}
+
+func _passwordConfig(password string) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+	}
+}
+
+func TestDialThroughJumpHosts(t *testing.T) {
+	first := _passwordServer(t, "first")
+	second := _passwordServer(t, "second")
+	target := _passwordServer(t, "target")
+
+	dialer := Dialer{
+		Host:         target,
+		ClientConfig: _passwordConfig("target"),
+		JumpHosts: []JumpHost{
+			{Host: first, ClientConfig: _passwordConfig("first")},
+			{Host: second, ClientConfig: _passwordConfig("second")},
+		},
+	}
+	client, err := dialer.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Couldn't connect through jump hosts: %s", err)
+	}
+	client.Close()
+}
+
+func TestDialJumpHostFailure(t *testing.T) {
+	first := _passwordServer(t, "first")
+	second := _passwordServer(t, "second")
+	target := _passwordServer(t, "target")
+
+	dialer := Dialer{
+		Host:         target,
+		ClientConfig: _passwordConfig("target"),
+		JumpHosts: []JumpHost{
+			{Host: first, ClientConfig: _passwordConfig("first")},
+			{Host: second, ClientConfig: _passwordConfig("wrong")},
+		},
+	}
+	_, err := dialer.Dial(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), "jump host #2 ("+second+")") {
+		t.Errorf("Error doesn't name the failing hop: %v", err)
+	}
+
+	dialer = Dialer{
+		Host:         target,
+		ClientConfig: _passwordConfig("wrong"),
+		JumpHosts:    []JumpHost{{Host: first, ClientConfig: _passwordConfig("first")}},
+	}
+	_, err = dialer.Dial(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), "remote host "+target) {
+		t.Errorf("Error doesn't name the failing hop: %v", err)
+	}
+}
+
+func TestDialRetryUntilHostIsUp(t *testing.T) {
+	// Reserve a port, and only start the server on it after a while
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := listener.Addr().String()
+	listener.Close()
+
+	time.AfterFunc(1500*time.Millisecond, func() {
+		listener, err := net.Listen("tcp", host)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_sshServe(t, listener, &ssh.ServerConfig{NoClientAuth: true})
+	})
+
+	dialer := Dialer{Host: host, ClientConfig: _passwordConfig("password"), ConnectRetryTimeout: 10 * time.Second}
+	client, err := dialer.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Didn't connect once the host was up: %s", err)
+	}
+	client.Close()
+}
+
+func TestDialRetryGiveUp(t *testing.T) {
+	// Nothing listens on the port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := listener.Addr().String()
+	listener.Close()
+
+	dialer := Dialer{Host: host, ClientConfig: _passwordConfig("password"), ConnectRetryTimeout: 1500 * time.Millisecond}
+	_, err = dialer.Dial(context.Background())
+	if err == nil || !strings.Contains(err.Error(), " attempts in 1.5s") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Error doesn't report attempts and last error: %v", err)
+	}
+}
+
+func TestDialNoRetry(t *testing.T) {
+	host := _passwordServer(t, "password")
+	verifier, err := newHostKeyVerifier(HostKeyCheckStrict, "", []string{"SHA256:AAAA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeyConfig := _passwordConfig("password")
+	hostKeyConfig.HostKeyCallback = verifier.verify
+	negotiationConfig := _passwordConfig("password")
+	negotiationConfig.KeyExchanges = []string{"diffie-hellman-group1-sha1"}
+
+	// Failures that would happen again are reported right away
+	for name, clientConfig := range map[string]*ssh.ClientConfig{
+		"authentication": _passwordConfig("wrong"),
+		"host key":       hostKeyConfig,
+		"negotiation":    negotiationConfig,
+	} {
+		dialer := Dialer{Host: host, ClientConfig: clientConfig, ConnectRetryTimeout: time.Minute}
+		start := time.Now()
+		_, err := dialer.Dial(context.Background())
+		if err == nil || strings.Contains(err.Error(), "attempts") || time.Since(start) > 5*time.Second {
+			t.Errorf("%s: unexpected retries: %v", name, err)
+		}
+	}
+}
+
+func TestDialRetryReset(t *testing.T) {
+	// Closes the first connections before the handshake, like a booting host
+	target := _passwordServer(t, "password")
+	var mu sync.Mutex
+	attempts := 0
+	host := _listen(t, func(conn net.Conn) {
+		mu.Lock()
+		attempts++
+		proxied := attempts > 2
+		mu.Unlock()
+		if !proxied {
+			conn.Close()
+			return
+		}
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		_pipe(conn, upstream)
+	})
+
+	dialer := Dialer{Host: host, ClientConfig: _passwordConfig("password"), ConnectRetryTimeout: 30 * time.Second}
+	client, err := dialer.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Didn't connect once the host was up: %s", err)
+	}
+	client.Close()
+}
+
+func TestDialConnectTimeout(t *testing.T) {
+	// Accepts connections, but never answers
+	host := _listen(t, func(conn net.Conn) {})
+
+	dialer := Dialer{Host: host, ClientConfig: _passwordConfig("password"), ConnectTimeout: 200 * time.Millisecond}
+	_, err := dialer.Dial(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Didn't time out: %v", err)
+	}
+}
+
+func TestDialThroughConnectTimeout(t *testing.T) {
+	// A jump host leaving the channels to the remote host unanswered
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(_signer(t))
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for range channels {
+				}
+			}()
+		}
+	}()
+
+	dialer := Dialer{
+		Host:           _passwordServer(t, "target"),
+		ClientConfig:   _passwordConfig("target"),
+		JumpHosts:      []JumpHost{{Host: listener.Addr().String(), ClientConfig: _passwordConfig("first")}},
+		ConnectTimeout: 200 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err = dialer.Dial(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "tunnel opening timed out") || time.Since(start) > 5*time.Second {
+		t.Errorf("Didn't time out: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialectProbe prints the kernel name and the output of `stat --version`,
+// which BSD stat rejects and BusyBox answers with its usage.
+const dialectProbe = "uname -s; stat --version 2>&1"
+
+// dialect is the flavour of the command line tools of the remote host.
+type dialect struct {
+	name string
+
+	// statFlag introduces the format of stat, and statFormats maps the
+	// characters of GNU `stat -c` formats to the formats of the dialect.
+	statFlag    string
+	statFormats map[string]string
+
+	// statAllFormat prints the type, permissions, uid, gid, owner name, group
+	// name, size and modification time of a file.
+	statAllFormat string
+
+	// hashCommands print the SHA-256 of a file, the first one available is
+	// used.
+	hashCommands []string
+}
+
+var (
+	// BusyBox is treated as GNU: its `stat -c` takes the same format
+	// characters and prints them alike, file types included.
+	gnuDialect = &dialect{
+		name:          "GNU",
+		statFlag:      "-c",
+		statFormats:   map[string]string{"a": "%a", "u": "%u", "g": "%g", "U": "%U", "G": "%G"},
+		statAllFormat: "%F|%a|%u|%g|%U|%G|%s|%Y",
+		hashCommands:  []string{"sha256sum"},
+	}
+	// %Mp%Lp prints the special and the permission bits of the mode, e.g. 0644
+	bsdDialect = &dialect{
+		name:          "BSD",
+		statFlag:      "-f",
+		statFormats:   map[string]string{"a": "%Mp%Lp", "u": "%u", "g": "%g", "U": "%Su", "G": "%Sg"},
+		statAllFormat: "%HT|%Mp%Lp|%u|%g|%Su|%Sg|%z|%m",
+		hashCommands:  []string{"sha256 -q", "shasum -a 256"},
+	}
+)
+
+// parseDialect recognizes the dialect from the output of dialectProbe,
+// defaulting to GNU, BusyBox included.
+func parseDialect(output string) *dialect {
+	kernel, stat, _ := strings.Cut(output, "\n")
+	kernel = strings.TrimSpace(kernel)
+
+	switch {
+	case strings.Contains(stat, "GNU coreutils"):
+		return gnuDialect
+	case strings.HasSuffix(kernel, "BSD") || kernel == "Darwin" || kernel == "DragonFly":
+		return bsdDialect
+	default:
+		return gnuDialect
+	}
+}
+
+// statCommand returns the command printing the attribute of p that `stat -c
+// %<char>` prints with GNU stat.
+func (d *dialect) statCommand(p string, char string) (string, error) {
+	format, ok := d.statFormats[char]
+	if !ok {
+		return "", fmt.Errorf("unsupported stat format %%%s", char)
+	}
+	return fmt.Sprintf("stat %s %s %s", d.statFlag, format, p), nil
+}
+
+// parseStat parses the output of statCommand, returning the attribute as GNU
+// stat prints it.
+func (d *dialect) parseStat(output []byte, char string) (string, error) {
+	value := strings.TrimSpace(string(output))
+	switch char {
+	case "a":
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || mode > 07777 {
+			return "", fmt.Errorf("unexpected %s stat permissions %q", d.name, value)
+		}
+		return strconv.FormatUint(mode, 8), nil
+	case "u", "g":
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return "", fmt.Errorf("unexpected %s stat id %q", d.name, value)
+		}
+		return value, nil
+	default:
+		if value == "" || strings.Contains(value, "\n") {
+			return "", fmt.Errorf("unexpected %s stat name %q", d.name, value)
+		}
+		return value, nil
+	}
+}
+
+// dialect probes once the dialect of the remote host.
+func (c *RemoteClient) dialect(ctx context.Context) (*dialect, error) {
+	c.mu.Lock()
+	d := c.remoteDialect
+	c.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	output, err := c.output(ctx, true, false, dialectProbe)
+	if err != nil && !exited(err) {
+		return nil, err
+	}
+	d = parseDialect(string(output))
+	c.mu.Lock()
+	c.remoteDialect = d
+	c.mu.Unlock()
+	return d, nil
+}
@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// _gnuProbeOutput is the output of dialectProbe on Debian 12.
+const _gnuProbeOutput = `Linux
+stat (GNU coreutils) 9.1
+Copyright (C) 2022 Free Software Foundation, Inc.
+License GPLv3+: GNU GPL version 3 or later <https://gnu.org/licenses/gpl.html>.
+This is free software: you are free to change and redistribute it.
+There is NO WARRANTY, to the extent permitted by law.
+
+Written by Michael Meskes.
+`
+
+func TestParseDialect(t *testing.T) {
+	// Only the GNU output was recorded, the BusyBox and BSD ones are
+	// transcribed from the usage messages of their stat sources.
+	for _, test := range []struct {
+		host   string
+		output string
+		want   *dialect
+	}{
+		{"Debian 12", _gnuProbeOutput, gnuDialect},
+		{"Alpine", "Linux\nstat: unrecognized option: version\nBusyBox v1.36.1 (2023-07-27 17:12:24 UTC) multi-call binary.\n\n" +
+			"Usage: stat [-ltf] [-c FMT] FILE...\n", gnuDialect},
+		{"macOS", "Darwin\nstat: illegal option -- -\nusage: stat [-FLnq] [-f format | -l | -r | -s | -x] [-t timefmt] [file|handle ...]\n", bsdDialect},
+		{"FreeBSD", "FreeBSD\nstat: illegal option -- -\nusage: stat [-FLnq] [-f format | -l | -r | -s | -x] [-t timefmt] [file ...]\n", bsdDialect},
+		{"macOS with coreutils", "Darwin\n" + strings.TrimPrefix(_gnuProbeOutput, "Linux\n"), gnuDialect},
+		{"no output", "", gnuDialect},
+	} {
+		if got := parseDialect(test.output); got != test.want {
+			t.Errorf("%s: expected the %s dialect, got %s", test.host, test.want.name, got.name)
+		}
+	}
+}
+
+func TestDialectStat(t *testing.T) {
+	for _, test := range []struct {
+		dialect *dialect
+		char    string
+		cmd     string
+		output  string
+		want    string
+	}{
+		{gnuDialect, "a", "stat -c %a /etc/hosts", "644\n", "644"},
+		{gnuDialect, "a", "stat -c %a /usr/bin/sudo", "4755\n", "4755"},
+		{gnuDialect, "U", "stat -c %U /etc/hosts", "root\n", "root"},
+		{gnuDialect, "g", "stat -c %g /etc/hosts", "0\n", "0"},
+		{bsdDialect, "a", "stat -f %Mp%Lp /etc/hosts", "0644\n", "644"},
+		{bsdDialect, "a", "stat -f %Mp%Lp /usr/bin/sudo", "4755\n", "4755"},
+		{bsdDialect, "u", "stat -f %u /etc/hosts", "0\n", "0"},
+		{bsdDialect, "G", "stat -f %Sg /etc/hosts", "wheel\n", "wheel"},
+	} {
+		cmd, err := test.dialect.statCommand(test.cmd[strings.LastIndex(test.cmd, " ")+1:], test.char)
+		if err != nil || cmd != test.cmd {
+			t.Errorf("%s %s: unexpected command %q, %v", test.dialect.name, test.char, cmd, err)
+		}
+		if got, err := test.dialect.parseStat([]byte(test.output), test.char); err != nil || got != test.want {
+			t.Errorf("%s %s: unexpected value of %q: %q, %v", test.dialect.name, test.char, test.output, got, err)
+		}
+	}
+}
+
+func TestDialectStatBusyBox(t *testing.T) {
+	// BusyBox 1.36 `stat -c` output, transcribed from coreutils/stat.c, for
+	// every format character of statAllFormat
+	for _, test := range []struct {
+		output string
+		want   FileStat
+	}{
+		{"regular file|644|0|0|root|root|8|1700000000\n" + _contentHash + "  /etc/motd\n",
+			FileStat{Type: FileTypeFile, Permissions: "0644", OwnerName: "root", GroupName: "root", Size: 8, Hash: _contentHash}},
+		{"regular empty file|600|1000|1000|alpine|alpine|0|1700000000\n",
+			FileStat{Type: FileTypeFile, Permissions: "0600", Owner: 1000, Group: 1000, OwnerName: "alpine", GroupName: "alpine"}},
+		{"directory|1777|0|0|root|root|4096|1700000000\n",
+			FileStat{Type: FileTypeDirectory, Permissions: "1777", OwnerName: "root", GroupName: "root", Size: 4096}},
+		{"symbolic link|777|0|0|root|root|12|1700000000\n",
+			FileStat{Type: FileTypeSymlink, Permissions: "0777", OwnerName: "root", GroupName: "root", Size: 12}},
+		{"fifo|644|0|0|root|root|0|1700000000\n",
+			FileStat{Type: FileTypeOther, Permissions: "0644", OwnerName: "root", GroupName: "root"}},
+	} {
+		got, err := gnuDialect.parseStatAll([]byte(test.output))
+		if err != nil {
+			t.Errorf("Couldn't parse %q: %s", test.output, err)
+			continue
+		}
+		test.want.Exists = true
+		if got.ModTime.Unix() != 1700000000 {
+			t.Errorf("Unexpected modification time of %q: %s", test.output, got.ModTime)
+		}
+		got.ModTime = test.want.ModTime
+		if got != test.want {
+			t.Errorf("Unexpected stat of %q: %+v", test.output, got)
+		}
+	}
+
+	for char, output := range map[string]string{"a": "4755\n", "u": "0\n", "g": "101\n", "U": "root\n", "G": "wheel\n"} {
+		if got, err := gnuDialect.parseStat([]byte(output), char); err != nil || got != strings.TrimSpace(output) {
+			t.Errorf("%s: unexpected value of %q: %q, %v", char, output, got, err)
+		}
+	}
+}
+
+func TestDialectStatUnexpectedOutput(t *testing.T) {
+	for _, test := range []struct {
+		dialect *dialect
+		char    string
+		output  string
+	}{
+		// GNU formats given to BSD stat, which reads them as file names
+		{bsdDialect, "a", "  File: \"%a\"\n"},
+		{gnuDialect, "u", "\n"},
+		{gnuDialect, "a", "-rw-r--r--\n"},
+		{gnuDialect, "U", ""},
+	} {
+		if got, err := test.dialect.parseStat([]byte(test.output), test.char); err == nil {
+			t.Errorf("%s %s: expected an error for %q, got %q", test.dialect.name, test.char, test.output, got)
+		}
+	}
+}
+
+func TestStatFileProbesDialect(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, cmd)
+		switch cmd {
+		case dialectProbe:
+			fmt.Fprint(stdout, "FreeBSD\nstat: illegal option -- -\nusage: stat [-FLnq] [-f format | -l | -r | -s | -x] [-t timefmt] [file ...]\n")
+		case "stat -f %Mp%Lp /etc/hosts":
+			fmt.Fprint(stdout, "0644\n")
+		case "stat -f %Su /etc/hosts":
+			fmt.Fprint(stdout, "root\n")
+		default:
+			fmt.Fprintf(stderr, "stat: %s: No such file or directory\n", cmd)
+			return 1
+		}
+		return 0
+	})
+	client := _remoteClient(t, host)
+
+	if permissions, err := client.ReadFilePermissions(context.Background(), "/etc/hosts", false); err != nil || permissions != "0644" {
+		t.Errorf("Unexpected permissions: %q, %v", permissions, err)
+	}
+	if owner, err := client.ReadFileOwnerName(context.Background(), "/etc/hosts", false); err != nil || owner != "root" {
+		t.Errorf("Unexpected owner: %q, %v", owner, err)
+	}
+	// The dialect is probed once
+	mu.Lock()
+	defer mu.Unlock()
+	if len(commands) != 3 || commands[0] != dialectProbe {
+		t.Errorf("Unexpected commands: %q", commands)
+	}
+}
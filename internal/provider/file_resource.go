@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
@@ -25,21 +27,57 @@ func NewFileResource() resource.Resource {
 
 // fileResource is the resource implementation.
 type fileResource struct {
-	client *RemoteClient
+	clients *remoteClients
 }
 
 // fileResourceModel maps the resource schema data.
 type fileResourceModel struct {
-	ID          types.String `tfsdk:"id"`
-	Path        types.String `tfsdk:"path"`
-	EnsureDir   types.Bool   `tfsdk:"ensure_dir"`
-	Content     types.String `tfsdk:"content"`
-	Owner       types.Int64  `tfsdk:"owner"`
-	OwnerName   types.String `tfsdk:"owner_name"`
-	Group       types.Int64  `tfsdk:"group"`
-	GroupName   types.String `tfsdk:"group_name"`
-	Permissions types.String `tfsdk:"permissions"`
-	LastUpdated types.String `tfsdk:"last_updated"`
+	ID          types.String   `tfsdk:"id"`
+	Path        types.String   `tfsdk:"path"`
+	EnsureDir   types.Bool     `tfsdk:"ensure_dir"`
+	Content     types.String   `tfsdk:"content"`
+	Owner       types.Int64    `tfsdk:"owner"`
+	OwnerName   types.String   `tfsdk:"owner_name"`
+	Group       types.Int64    `tfsdk:"group"`
+	GroupName   types.String   `tfsdk:"group_name"`
+	Permissions types.String   `tfsdk:"permissions"`
+	LastUpdated types.String   `tfsdk:"last_updated"`
+	Sudo        types.Bool     `tfsdk:"sudo"`
+	RunAs       types.String   `tfsdk:"run_as"`
+	Shell       types.String   `tfsdk:"shell"`
+	Environment types.Map      `tfsdk:"environment"`
+	Umask       types.String   `tfsdk:"umask"`
+	WorkingDir  types.String   `tfsdk:"working_dir"`
+	Connection  *hostModel     `tfsdk:"conn"`
+	Timeouts    timeouts.Value `tfsdk:"timeouts"`
+}
+
+// commandSettings returns the attributes changing how commands run.
+func (m fileResourceModel) commandSettings() commandSettings {
+	return commandSettings{
+		Sudo:        m.Sudo,
+		RunAs:       m.RunAs,
+		Shell:       m.Shell,
+		Environment: m.Environment,
+		Umask:       m.Umask,
+		WorkingDir:  m.WorkingDir,
+	}
+}
+
+// readFile reads the attributes and the content of the file at path. The
+// content is only fetched if its hash differs from the one of known.
+func readFile(ctx context.Context, client *RemoteClient, path string, sudo bool, known string) (FileStat, string, error) {
+	stat, err := client.StatAll(ctx, path, sudo)
+	if err != nil || !stat.Exists {
+		return stat, "", err
+	}
+	if stat.HasContent(known) {
+		return stat, known, nil
+	}
+
+	content, exists, err := client.ReadFile(ctx, path, sudo)
+	stat.Exists = exists
+	return stat, content, err
 }
 
 // Configure adds the provider configured client to the resource.
@@ -48,18 +86,18 @@ func (r *fileResource) Configure(_ context.Context, req resource.ConfigureReques
 		return
 	}
 
-	client, ok := req.ProviderData.(*RemoteClient)
+	clients, ok := req.ProviderData.(*remoteClients)
 
 	if !ok {
 		resp.Diagnostics.AddError(
 			"Unexpected Data Source Configure Type",
-			fmt.Sprintf("Expected *hashicups.Client, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+			fmt.Sprintf("Expected *remoteClients, got: %T. Please report this issue to the provider developers.", req.ProviderData),
 		)
 
 		return
 	}
 
-	r.client = client
+	r.clients = clients
 }
 
 // Metadata returns the resource type name.
@@ -68,7 +106,7 @@ func (r *fileResource) Metadata(_ context.Context, req resource.MetadataRequest,
 }
 
 // Schema defines the schema for the resource.
-func (r *fileResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *fileResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		Attributes: map[string]schema.Attribute{
 			"id": schema.StringAttribute{
@@ -119,6 +157,40 @@ func (r *fileResource) Schema(_ context.Context, _ resource.SchemaRequest, resp
 				Optional: true,
 				Computed: true,
 			},
+			"sudo": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether commands run through the provider become method, sudo by default. Default is the provider setting",
+			},
+			"run_as": schema.StringAttribute{
+				Optional:    true,
+				Description: "User to run commands as, through the provider become method, sudo by default. example: `app`",
+			},
+			"shell": schema.StringAttribute{
+				Optional:    true,
+				Description: "Shell running commands with `-c`. Default is the provider setting",
+			},
+			"environment": schema.MapAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Environment variables of commands, merged with the provider ones",
+			},
+			"umask": schema.StringAttribute{
+				Optional:    true,
+				Description: "Umask of commands, in octal. example: `022`. Default is the provider setting",
+			},
+			"working_dir": schema.StringAttribute{
+				Optional:    true,
+				Description: "Absolute path of the directory commands run in. Default is the provider setting",
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"conn": connectionBlock(),
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
 		},
 	}
 }
@@ -133,42 +205,62 @@ func (r *fileResource) Create(ctx context.Context, req resource.CreateRequest, r
 		return
 	}
 
+	timeout, diags := plan.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, plan.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, plan.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	path := plan.Path.ValueString()
 	content := plan.Content.ValueString()
 
 	state.ID = plan.Path
 
-	err := r.client.WriteFile(content, path, true, plan.EnsureDir.ValueBool())
+	err := client.WriteFile(ctx, content, path, sudo, plan.EnsureDir.ValueBool())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error creating file",
-			"Could not create file, unexpected error: "+err.Error(),
+			"Could not create file, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	if !plan.Owner.IsUnknown() {
-		err = r.client.ChownFile(path, plan.Owner.String(), true)
+		err = client.ChownFile(ctx, path, plan.Owner.String(), sudo)
 	} else if !plan.OwnerName.IsUnknown() {
-		err = r.client.ChownFile(path, plan.OwnerName.ValueString(), true)
+		err = client.ChownFile(ctx, path, plan.OwnerName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder user ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	if !plan.Group.IsUnknown() {
-		err = r.client.ChgrpFile(path, plan.Group.String(), true)
+		err = client.ChgrpFile(ctx, path, plan.Group.String(), sudo)
 	} else if !plan.GroupName.IsUnknown() {
-		err = r.client.ChgrpFile(path, plan.GroupName.ValueString(), true)
+		err = client.ChgrpFile(ctx, path, plan.GroupName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder group ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
@@ -177,48 +269,29 @@ func (r *fileResource) Create(ctx context.Context, req resource.CreateRequest, r
 	state.Path = plan.Path
 	state.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
 
-	content, _, err = r.client.ReadFile(path, true)
+	stat, content, err := readFile(ctx, client, path, sudo, content)
 	if err != nil {
-		resp.Diagnostics.AddError("Couldn't read file content after creation", err.Error())
+		addCommandError(&resp.Diagnostics, "Couldn't read file after creation", "", err)
 		return
 	}
-	//resp.Diagnostics.AddError("Something went wrong", "content is "+content)
-	//return
 
-	group, err := r.client.ReadFileGroup(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load file group id after creation", err.Error())
-        return
-    }
-	owner, err := r.client.ReadFileOwner(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load file owner id after creation", err.Error())
-        return
-    }
-	groupName, err := r.client.ReadFileGroupName(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load file group name after creation", err.Error())
-        return
-    }
-	ownerName, err := r.client.ReadFileOwnerName(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load file owner name after creation", err.Error())
-        return
-    }
-	permissions, err := r.client.ReadFilePermissions(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load file permissions after creation", err.Error())
-        return
-    }
-
-	state.Owner = types.Int64Value(parseInt(owner))
-	state.Group = types.Int64Value(parseInt(group))
-	state.OwnerName = types.StringValue(ownerName)
-	state.GroupName = types.StringValue(groupName)
-	state.Permissions = types.StringValue(permissions)
+	state.Owner = types.Int64Value(stat.Owner)
+	state.Group = types.Int64Value(stat.Group)
+	state.OwnerName = types.StringValue(stat.OwnerName)
+	state.GroupName = types.StringValue(stat.GroupName)
+	state.Permissions = types.StringValue(stat.Permissions)
 	state.Content = types.StringValue(content)
 	state.EnsureDir = plan.EnsureDir
 
+	state.Sudo = plan.Sudo
+	state.RunAs = plan.RunAs
+	state.Shell = plan.Shell
+	state.Environment = plan.Environment
+	state.Umask = plan.Umask
+	state.WorkingDir = plan.WorkingDir
+	state.Connection = plan.Connection
+	state.Timeouts = plan.Timeouts
+
 	// Set state to fully populated data
 	diags = resp.State.Set(ctx, state)
 	resp.Diagnostics.Append(diags...)
@@ -237,35 +310,53 @@ func (r *fileResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		return
 	}
 
+	if r.clients.Unknown() {
+		// The host is yet to be created, e.g. in the same plan, keep the
+		// state as is
+		return
+	}
+
+	timeout, diags := state.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, state.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, state.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	path := state.ID.ValueString()
 
 	// Get refreshed folder value from HashiCups
-	content, fileExists, err := r.client.ReadFile(path, true)
+	stat, content, err := readFile(ctx, client, path, sudo, state.Content.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error Reading remote file",
-			"Could not read remote file ID "+state.ID.ValueString()+": "+err.Error(),
+			"Could not read remote file ID "+state.ID.ValueString()+": ",
+			err,
 		)
 		return
 	}
 
-	if !fileExists {
+	if !stat.Exists {
 		resp.State.RemoveResource(ctx)
 		return
 	}
 
-	group, _ := r.client.ReadFileGroup(path, true)
-	owner, _ := r.client.ReadFileOwner(path, true)
-	groupName, _ := r.client.ReadFileGroupName(path, true)
-	ownerName, _ := r.client.ReadFileOwnerName(path, true)
-	permissions, _ := r.client.ReadFilePermissions(path, true)
-
 	state.Content = types.StringValue(content)
-	state.Owner = types.Int64Value(parseInt(owner))
-	state.Group = types.Int64Value(parseInt(group))
-	state.OwnerName = types.StringValue(ownerName)
-	state.GroupName = types.StringValue(groupName)
-	state.Permissions = types.StringValue(permissions)
+	state.Owner = types.Int64Value(stat.Owner)
+	state.Group = types.Int64Value(stat.Group)
+	state.OwnerName = types.StringValue(stat.OwnerName)
+	state.GroupName = types.StringValue(stat.GroupName)
+	state.Permissions = types.StringValue(stat.Permissions)
 
 	// Set refreshed state
 	diags = resp.State.Set(ctx, &state)
@@ -287,65 +378,92 @@ func (r *fileResource) Update(ctx context.Context, req resource.UpdateRequest, r
 		return
 	}
 
+	timeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, plan.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, plan.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	path := state.ID.ValueString()
 
 	var err error
 
 	if !plan.Content.IsUnknown() && plan.Content != state.Content {
 		// path didn't change, no reason to ensureDir
-		err = r.client.WriteFile(plan.Content.ValueString(), path, true, false)
+		err = client.WriteFile(ctx, plan.Content.ValueString(), path, sudo, false)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating file content",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	if !plan.Owner.IsUnknown() && plan.Owner != state.Owner {
-		err = r.client.ChownFile(path, plan.Owner.String(), true)
+		err = client.ChownFile(ctx, path, plan.Owner.String(), sudo)
 	} else if !plan.OwnerName.IsUnknown() && !plan.OwnerName.Equal(state.OwnerName) {
-		err = r.client.ChownFile(path, plan.OwnerName.ValueString(), true)
+		err = client.ChownFile(ctx, path, plan.OwnerName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder user ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	if !plan.Group.IsUnknown() && plan.Group != state.Group {
-		err = r.client.ChgrpFile(path, plan.Group.String(), true)
+		err = client.ChgrpFile(ctx, path, plan.Group.String(), sudo)
 	} else if !plan.GroupName.IsUnknown() && !plan.GroupName.Equal(state.GroupName) {
-		err = r.client.ChgrpFile(path, plan.GroupName.ValueString(), true)
+		err = client.ChgrpFile(ctx, path, plan.GroupName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder group ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	state.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
 
-	content, _, _ := r.client.ReadFile(path, true)
-	group, _ := r.client.ReadFileGroup(path, true)
-	owner, _ := r.client.ReadFileOwner(path, true)
-	groupName, _ := r.client.ReadFileGroupName(path, true)
-	ownerName, _ := r.client.ReadFileOwnerName(path, true)
-	permissions, _ := r.client.ReadFilePermissions(path, true)
+	stat, content, err := readFile(ctx, client, path, sudo, plan.Content.ValueString())
+	if err != nil {
+		addCommandError(&resp.Diagnostics, "Error updating file", "Couldn't read file after update: ", err)
+		return
+	}
 
 	state.Content = types.StringValue(content)
-	state.Owner = types.Int64Value(parseInt(owner))
-	state.Group = types.Int64Value(parseInt(group))
-	state.OwnerName = types.StringValue(ownerName)
-	state.GroupName = types.StringValue(groupName)
-	state.Permissions = types.StringValue(permissions)
+	state.Owner = types.Int64Value(stat.Owner)
+	state.Group = types.Int64Value(stat.Group)
+	state.OwnerName = types.StringValue(stat.OwnerName)
+	state.GroupName = types.StringValue(stat.GroupName)
+	state.Permissions = types.StringValue(stat.Permissions)
+	state.Sudo = plan.Sudo
+	state.RunAs = plan.RunAs
+	state.Shell = plan.Shell
+	state.Environment = plan.Environment
+	state.Umask = plan.Umask
+	state.WorkingDir = plan.WorkingDir
+	state.Connection = plan.Connection
+	state.Timeouts = plan.Timeouts
 
-	diags := resp.State.Set(ctx, state)
+	diags = resp.State.Set(ctx, state)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
@@ -362,14 +480,32 @@ func (r *fileResource) Delete(ctx context.Context, req resource.DeleteRequest, r
 		return
 	}
 
+	timeout, diags := state.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, state.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, state.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	path := state.ID.ValueString()
 
 	// Delete existing order
-	err := r.client.DeleteFile(path, true)
+	err := client.DeleteFile(ctx, path, sudo)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error Deleting HashiCups Order",
-			"Could not delete order, unexpected error: "+err.Error(),
+			"Could not delete order, unexpected error: ",
+			err,
 		)
 		return
 
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestFileCreateTimeout(t *testing.T) {
+	host, stopped := _hangingServer(t)
+	r := &fileResource{clients: _remoteClients()}
+	ctx := context.Background()
+
+	var schemaResp resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	schema := schemaResp.Schema
+	typ := schema.Type().TerraformType(ctx).(tftypes.Object)
+	conn := typ.AttributeTypes["conn"].(tftypes.Object)
+	timeouts := typ.AttributeTypes["timeouts"].(tftypes.Object)
+	plan := _objectValue(typ, map[string]tftypes.Value{
+		"path":    tftypes.NewValue(tftypes.String, "/tmp/file"),
+		"content": tftypes.NewValue(tftypes.String, "content"),
+		"conn": _objectValue(conn, map[string]tftypes.Value{
+			"host":     tftypes.NewValue(tftypes.String, host),
+			"username": tftypes.NewValue(tftypes.String, "root"),
+			"password": tftypes.NewValue(tftypes.String, "secret"),
+		}, func(string) bool { return false }),
+		"timeouts": _objectValue(timeouts, map[string]tftypes.Value{
+			"create": tftypes.NewValue(tftypes.String, "200ms"),
+		}, func(string) bool { return false }),
+	}, func(name string) bool {
+		attribute, ok := schema.Attributes[name]
+		return ok && attribute.IsComputed()
+	})
+
+	resp := resource.CreateResponse{State: tfsdk.State{Schema: schema, Raw: tftypes.NewValue(typ, nil)}}
+	start := time.Now()
+	r.Create(ctx, resource.CreateRequest{Plan: tfsdk.Plan{Schema: schema, Raw: plan}}, &resp)
+	if resp.Diagnostics.ErrorsCount() != 1 || resp.Diagnostics[0].Summary() != "Remote command timed out" {
+		t.Errorf("Unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Create wasn't bounded by its timeout: %s", elapsed)
+	}
+	_waitStopped(t, stopped)
+}
+
+func TestFileDefaultTimeouts(t *testing.T) {
+	ctx := context.Background()
+	var model fileResourceModel
+	var schemaResp resource.SchemaResponse
+	(&fileResource{}).Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	// Without a timeouts block
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	state := tfsdk.State{Schema: schemaResp.Schema, Raw: _objectValue(typ, nil, func(string) bool { return false })}
+	if diags := state.Get(ctx, &model); diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	create, _ := model.Timeouts.Create(ctx, defaultCreateTimeout)
+	read, _ := model.Timeouts.Read(ctx, defaultReadTimeout)
+	update, _ := model.Timeouts.Update(ctx, defaultUpdateTimeout)
+	del, _ := model.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	if create != 20*time.Minute || read != 5*time.Minute || update != 20*time.Minute || del != 20*time.Minute {
+		t.Errorf("Unexpected default timeouts: %s, %s, %s, %s", create, read, update, del)
+	}
+}
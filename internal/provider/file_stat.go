@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File types of FileStat.
+const (
+	FileTypeFile      = "file"
+	FileTypeDirectory = "directory"
+	FileTypeSymlink   = "symlink"
+	FileTypeOther     = "other"
+)
+
+// FileStat is the attributes of a file, read at once by StatAll.
+type FileStat struct {
+	Exists bool
+
+	Type        string
+	Permissions string // in octal, e.g. 0644
+	Owner       int64
+	Group       int64
+	OwnerName   string
+	GroupName   string
+	Size        int64
+	ModTime     time.Time
+
+	// Hash is the hex SHA-256 of the content of regular files, empty for other
+	// files or if the remote host has no tool to compute it.
+	Hash string
+}
+
+// HasContent tells whether the content of the file is content, according to
+// its hash. It's false if the hash is unknown.
+func (s FileStat) HasContent(content string) bool {
+	hash := sha256.Sum256([]byte(content))
+	return s.Hash != "" && s.Hash == hex.EncodeToString(hash[:])
+}
+
+// statAllFields is the number of fields printed by the stat of statAllCommand.
+const statAllFields = 8
+
+// statAllCommand returns the command printing the attributes of p, if it
+// exists, separated by `|`, followed by the line of the hash tool for regular
+// files.
+func (d *dialect) statAllCommand(p string) string {
+	hash := make([]string, 0, len(d.hashCommands)+1)
+	for _, command := range d.hashCommands {
+		hash = append(hash, fmt.Sprintf("%s %s 2>/dev/null", command, p))
+	}
+	hash = append(hash, "true")
+
+	return fmt.Sprintf("if [ -e %[1]s ] || [ -L %[1]s ]; then stat %[2]s '%[3]s' %[1]s && if [ -f %[1]s ]; then %[4]s; fi; fi",
+		p, d.statFlag, d.statAllFormat, strings.Join(hash, " || "))
+}
+
+// parseStatAll parses the output of statAllCommand.
+func (d *dialect) parseStatAll(output []byte) (FileStat, error) {
+	lines := strings.SplitN(strings.TrimRight(string(output), "\n"), "\n", 2)
+	if lines[0] == "" {
+		return FileStat{}, nil
+	}
+
+	fields := strings.Split(lines[0], "|")
+	if len(fields) != statAllFields {
+		return FileStat{}, fmt.Errorf("unexpected %s stat output %q", d.name, lines[0])
+	}
+	stat := FileStat{Exists: true, Type: fileType(fields[0]), OwnerName: fields[4], GroupName: fields[5]}
+
+	mode, err := strconv.ParseUint(fields[1], 8, 32)
+	if err != nil || mode > 07777 {
+		return FileStat{}, fmt.Errorf("unexpected %s stat permissions %q", d.name, fields[1])
+	}
+	stat.Permissions = padPermissions(strconv.FormatUint(mode, 8))
+
+	numbers := make([]int64, 4)
+	for i, field := range []string{fields[2], fields[3], fields[6], fields[7]} {
+		numbers[i], err = strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return FileStat{}, fmt.Errorf("unexpected %s stat output %q", d.name, lines[0])
+		}
+	}
+	stat.Owner, stat.Group, stat.Size = numbers[0], numbers[1], numbers[2]
+	stat.ModTime = time.Unix(numbers[3], 0)
+
+	// sha256sum and shasum print the hash then the path, sha256 -q only the hash
+	if len(lines) > 1 {
+		hash := strings.Fields(lines[1])
+		if len(hash) > 0 && len(hash[0]) == sha256.Size*2 {
+			if _, err := hex.DecodeString(hash[0]); err == nil {
+				stat.Hash = strings.ToLower(hash[0])
+			}
+		}
+	}
+	return stat, nil
+}
+
+// fileType normalizes the file types printed by GNU stat, e.g. `regular empty
+// file`, and BSD stat, e.g. `Regular File`.
+func fileType(description string) string {
+	description = strings.ToLower(description)
+	switch {
+	case strings.HasPrefix(description, "regular"):
+		return FileTypeFile
+	case description == "directory":
+		return FileTypeDirectory
+	case description == "symbolic link":
+		return FileTypeSymlink
+	default:
+		return FileTypeOther
+	}
+}
+
+// padPermissions prefixes permissions with zeros, as `stat` prints `644` for
+// `0644`.
+func padPermissions(permissions string) string {
+	if len(permissions) > 0 && len(permissions) < 4 {
+		permissions = strings.Repeat("0", 4-len(permissions)) + permissions
+	}
+	return permissions
+}
+
+// StatAll reads the attributes of path with a single command. It runs a shell
+// command whatever the transport, as SFTP knows neither owner and group names
+// nor content hashes.
+func (c *RemoteClient) StatAll(ctx context.Context, path string, sudo bool) (FileStat, error) {
+	dialect, err := c.dialect(ctx)
+	if err != nil {
+		return FileStat{}, err
+	}
+	output, err := c.output(ctx, true, sudo, dialect.statAllCommand(path))
+	if err != nil {
+		return FileStat{}, err
+	}
+	return dialect.parseStatAll(output)
+}
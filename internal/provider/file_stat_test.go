@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// _contentHash is the SHA-256 of "content\n".
+const _contentHash = "434728a410a78f56fc1b5899c3593436e61ab0c731e9072d95e96db290205e53"
+
+func TestParseStatAll(t *testing.T) {
+	for _, test := range []struct {
+		dialect *dialect
+		output  string
+		want    FileStat
+	}{
+		{gnuDialect, "regular file|644|0|0|root|root|8|1700000000\n" + _contentHash + "  /etc/motd\n",
+			FileStat{Type: FileTypeFile, Permissions: "0644", OwnerName: "root", GroupName: "root", Size: 8, Hash: _contentHash}},
+		{gnuDialect, "regular empty file|4755|1000|100|deploy|users|0|1700000000\n",
+			FileStat{Type: FileTypeFile, Permissions: "4755", Owner: 1000, Group: 100, OwnerName: "deploy", GroupName: "users"}},
+		{gnuDialect, "directory|1777|0|0|root|root|4096|1700000000\n",
+			FileStat{Type: FileTypeDirectory, Permissions: "1777", OwnerName: "root", GroupName: "root", Size: 4096}},
+		{bsdDialect, "Regular File|0644|0|0|root|wheel|8|1700000000\n" + _contentHash + "\n",
+			FileStat{Type: FileTypeFile, Permissions: "0644", OwnerName: "root", GroupName: "wheel", Size: 8, Hash: _contentHash}},
+		{bsdDialect, "Symbolic Link|0755|501|20|admin|staff|11|1700000000\n",
+			FileStat{Type: FileTypeSymlink, Permissions: "0755", Owner: 501, Group: 20, OwnerName: "admin", GroupName: "staff", Size: 11}},
+		// No hash tool on the remote host
+		{gnuDialect, "regular file|600|0|0|root|root|8|1700000000\n",
+			FileStat{Type: FileTypeFile, Permissions: "0600", OwnerName: "root", GroupName: "root", Size: 8}},
+	} {
+		got, err := test.dialect.parseStatAll([]byte(test.output))
+		if err != nil {
+			t.Errorf("%s: couldn't parse %q: %s", test.dialect.name, test.output, err)
+			continue
+		}
+		test.want.Exists = true
+		if got.ModTime.Unix() != 1700000000 {
+			t.Errorf("%s: unexpected modification time of %q: %s", test.dialect.name, test.output, got.ModTime)
+		}
+		got.ModTime = test.want.ModTime
+		if got != test.want {
+			t.Errorf("%s: unexpected stat of %q: %+v", test.dialect.name, test.output, got)
+		}
+	}
+
+	if stat, err := gnuDialect.parseStatAll(nil); err != nil || stat.Exists {
+		t.Errorf("Unexpected stat of a missing file: %+v, %v", stat, err)
+	}
+	if _, err := bsdDialect.parseStatAll([]byte("  File: \"%HT|%Mp%Lp\"\n")); err == nil {
+		t.Errorf("Expected an error for GNU output parsed as BSD")
+	}
+}
+
+func TestStatAllCommand(t *testing.T) {
+	cmd := bsdDialect.statAllCommand("/etc/motd")
+	expected := "if [ -e /etc/motd ] || [ -L /etc/motd ]; then stat -f '%HT|%Mp%Lp|%u|%g|%Su|%Sg|%z|%m' /etc/motd && " +
+		"if [ -f /etc/motd ]; then sha256 -q /etc/motd 2>/dev/null || shasum -a 256 /etc/motd 2>/dev/null || true; fi; fi"
+	if cmd != expected {
+		t.Errorf("Unexpected command: %s", cmd)
+	}
+}
+
+func TestFileStatHasContent(t *testing.T) {
+	stat := FileStat{Hash: _contentHash}
+	if !stat.HasContent("content\n") || stat.HasContent("content") {
+		t.Errorf("Unexpected content comparison")
+	}
+	if (FileStat{}).HasContent("") {
+		t.Errorf("Content matched without hash")
+	}
+}
+
+// _shellServer runs the commands it receives with the local shell, recording
+// them.
+func _shellServer(t *testing.T) (string, func() []string) {
+	handler, commands := _shellHandler(t)
+	host, _ := _execServer(t, handler)
+	return host, commands
+}
+
+// _shellHandler runs commands with the local shell, recording them.
+func _shellHandler(t *testing.T) (_execHandler, func() []string) {
+	if _, err := exec.LookPath("stat"); err != nil {
+		t.Skip("no stat command")
+	}
+	var mu sync.Mutex
+	var commands []string
+	handler := func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		mu.Lock()
+		commands = append(commands, cmd)
+		mu.Unlock()
+		command := exec.Command("sh", "-c", cmd)
+		command.Stdin, command.Stdout, command.Stderr = stdin, stdout, stderr
+		if err := command.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return uint32(exitErr.ExitCode())
+			}
+			return 1
+		}
+		return 0
+	}
+	return handler, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, commands...)
+	}
+}
+
+func TestStatAll(t *testing.T) {
+	host, commands := _shellServer(t)
+	client := _remoteClient(t, host)
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("content\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := client.StatAll(ctx, file, false)
+	if err != nil {
+		t.Fatalf("Stat failed: %s", err)
+	}
+	ownerName, _ := client.ReadFileOwnerName(ctx, file, false)
+	if !stat.Exists || stat.Type != FileTypeFile || stat.Permissions != "0640" || stat.Size != 8 ||
+		stat.Owner != int64(os.Getuid()) || stat.OwnerName != ownerName {
+		t.Errorf("Unexpected stat: %+v", stat)
+	}
+	if _, err := exec.LookPath("sha256sum"); err == nil && stat.Hash != _contentHash {
+		t.Errorf("Unexpected hash: %q", stat.Hash)
+	}
+
+	if stat, err := client.StatAll(ctx, dir, false); err != nil || stat.Type != FileTypeDirectory || stat.Hash != "" {
+		t.Errorf("Unexpected stat of a folder: %+v, %v", stat, err)
+	}
+	if stat, err := client.StatAll(ctx, filepath.Join(dir, "missing"), false); err != nil || stat.Exists {
+		t.Errorf("Unexpected stat of a missing file: %+v, %v", stat, err)
+	}
+
+	// The dialect probe, then a single command per stat
+	if got := commands(); len(got) != 5 || got[0] != dialectProbe {
+		t.Errorf("Unexpected commands: %q", got)
+	}
+}
+
+func TestReadFileSkipsKnownContent(t *testing.T) {
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("no sha256sum command")
+	}
+	host, commands := _shellServer(t)
+	client := _remoteClient(t, host)
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, known := range []string{"content\n", "drifted\n"} {
+		stat, content, err := readFile(context.Background(), client, file, false, known)
+		if err != nil || !stat.Exists || content != "content\n" {
+			t.Errorf("Unexpected read: %+v, %q, %v", stat, content, err)
+		}
+	}
+	// The content is only read once it drifted
+	got := commands()
+	if len(got) != 4 || got[3] != "cat "+file {
+		t.Errorf("Unexpected commands: %q", got)
+	}
+}
@@ -3,9 +3,10 @@ package provider
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
@@ -26,19 +27,39 @@ func NewFolderResource() resource.Resource {
 
 // folderResource is the resource implementation.
 type folderResource struct {
-	client *RemoteClient
+	clients *remoteClients
 }
 
 // folderResourceModel maps the resource schema data.
 type folderResourceModel struct {
-	ID          types.String `tfsdk:"id"`
-	Path        types.String `tfsdk:"path"`
-	Owner       types.Int64  `tfsdk:"owner"`
-	OwnerName   types.String `tfsdk:"owner_name"`
-	Group       types.Int64  `tfsdk:"group"`
-	GroupName   types.String `tfsdk:"group_name"`
-	Permissions types.String `tfsdk:"permissions"`
-	LastUpdated types.String `tfsdk:"last_updated"`
+	ID          types.String   `tfsdk:"id"`
+	Path        types.String   `tfsdk:"path"`
+	Owner       types.Int64    `tfsdk:"owner"`
+	OwnerName   types.String   `tfsdk:"owner_name"`
+	Group       types.Int64    `tfsdk:"group"`
+	GroupName   types.String   `tfsdk:"group_name"`
+	Permissions types.String   `tfsdk:"permissions"`
+	LastUpdated types.String   `tfsdk:"last_updated"`
+	Sudo        types.Bool     `tfsdk:"sudo"`
+	RunAs       types.String   `tfsdk:"run_as"`
+	Shell       types.String   `tfsdk:"shell"`
+	Environment types.Map      `tfsdk:"environment"`
+	Umask       types.String   `tfsdk:"umask"`
+	WorkingDir  types.String   `tfsdk:"working_dir"`
+	Connection  *hostModel     `tfsdk:"conn"`
+	Timeouts    timeouts.Value `tfsdk:"timeouts"`
+}
+
+// commandSettings returns the attributes changing how commands run.
+func (m folderResourceModel) commandSettings() commandSettings {
+	return commandSettings{
+		Sudo:        m.Sudo,
+		RunAs:       m.RunAs,
+		Shell:       m.Shell,
+		Environment: m.Environment,
+		Umask:       m.Umask,
+		WorkingDir:  m.WorkingDir,
+	}
 }
 
 // Configure adds the provider configured client to the resource.
@@ -47,18 +68,18 @@ func (r *folderResource) Configure(_ context.Context, req resource.ConfigureRequ
 		return
 	}
 
-	client, ok := req.ProviderData.(*RemoteClient)
+	clients, ok := req.ProviderData.(*remoteClients)
 
 	if !ok {
 		resp.Diagnostics.AddError(
 			"Unexpected Data Source Configure Type",
-			fmt.Sprintf("Expected *hashicups.Client, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+			fmt.Sprintf("Expected *remoteClients, got: %T. Please report this issue to the provider developers.", req.ProviderData),
 		)
 
 		return
 	}
 
-	r.client = client
+	r.clients = clients
 }
 
 // Metadata returns the resource type name.
@@ -67,7 +88,7 @@ func (r *folderResource) Metadata(_ context.Context, req resource.MetadataReques
 }
 
 // Schema defines the schema for the resource.
-func (r *folderResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *folderResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		Attributes: map[string]schema.Attribute{
 			"id": schema.StringAttribute{
@@ -109,15 +130,44 @@ func (r *folderResource) Schema(_ context.Context, _ resource.SchemaRequest, res
 				Optional: true,
 				Computed: true,
 			},
+			"sudo": schema.BoolAttribute{
+				Optional:    true,
+				Description: "Whether commands run through the provider become method, sudo by default. Default is the provider setting",
+			},
+			"run_as": schema.StringAttribute{
+				Optional:    true,
+				Description: "User to run commands as, through the provider become method, sudo by default. example: `app`",
+			},
+			"shell": schema.StringAttribute{
+				Optional:    true,
+				Description: "Shell running commands with `-c`. Default is the provider setting",
+			},
+			"environment": schema.MapAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Environment variables of commands, merged with the provider ones",
+			},
+			"umask": schema.StringAttribute{
+				Optional:    true,
+				Description: "Umask of commands, in octal. example: `022`. Default is the provider setting",
+			},
+			"working_dir": schema.StringAttribute{
+				Optional:    true,
+				Description: "Absolute path of the directory commands run in. Default is the provider setting",
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"conn": connectionBlock(),
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
 		},
 	}
 }
 
-func parseInt(intStr string) int64 {
-	v, _ := strconv.ParseInt(intStr, 10, 32)
-	return v
-}
-
 // Create creates the resource and sets the initial Terraform state.
 func (r *folderResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	// Retrieve values from plan
@@ -128,40 +178,60 @@ func (r *folderResource) Create(ctx context.Context, req resource.CreateRequest,
 		return
 	}
 
-	path := plan.Path.String()
+	timeout, diags := plan.Timeouts.Create(ctx, defaultCreateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, plan.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, plan.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	path := plan.Path.ValueString()
 	state.ID = plan.Path
 
-	err := r.client.CreateDir(path, true)
+	err := client.CreateDir(ctx, path, sudo)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error creating folder",
-			"Could not create folder, unexpected error: "+err.Error(),
+			"Could not create folder, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	if !plan.Owner.IsUnknown() {
-		err = r.client.ChownFile(path, plan.Owner.String(), true)
+		err = client.ChownFile(ctx, path, plan.Owner.String(), sudo)
 	} else if !plan.OwnerName.IsUnknown() {
-		err = r.client.ChownFile(path, plan.OwnerName.String(), true)
+		err = client.ChownFile(ctx, path, plan.OwnerName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder user ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	if !plan.Group.IsUnknown() {
-		err = r.client.ChgrpFile(path, plan.Group.String(), true)
+		err = client.ChgrpFile(ctx, path, plan.Group.String(), sudo)
 	} else if !plan.GroupName.IsUnknown() {
-		err = r.client.ChgrpFile(path, plan.GroupName.String(), true)
+		err = client.ChgrpFile(ctx, path, plan.GroupName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder group ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
@@ -170,37 +240,26 @@ func (r *folderResource) Create(ctx context.Context, req resource.CreateRequest,
 	state.Path = plan.Path
 	state.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
 
-	group, err := r.client.ReadFileGroup(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load dir group id after creation", err.Error())
-        return
-    }
-	owner, err := r.client.ReadFileOwner(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load dir owner id after creation", err.Error())
-        return
-    }
-	groupName, err := r.client.ReadFileGroupName(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load dir group name after creation", err.Error())
-        return
-    }
-	ownerName, err := r.client.ReadFileOwnerName(path, true)
-	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load dir owner name after creation", err.Error())
-        return
-    }
-	permissions, err := r.client.ReadFilePermissions(path, true)
+	stat, err := client.StatAll(ctx, path, sudo)
 	if err != nil {
-        resp.Diagnostics.AddError("Couldn't load dir permissions name after creation", err.Error())
-        return
-    }
+		addCommandError(&resp.Diagnostics, "Couldn't load dir attributes after creation", "", err)
+		return
+	}
 
-	state.Owner = types.Int64Value(parseInt(owner))
-	state.Group = types.Int64Value(parseInt(group))
-	state.OwnerName = types.StringValue(ownerName)
-	state.GroupName = types.StringValue(groupName)
-	state.Permissions = types.StringValue(permissions)
+	state.Owner = types.Int64Value(stat.Owner)
+	state.Group = types.Int64Value(stat.Group)
+	state.OwnerName = types.StringValue(stat.OwnerName)
+	state.GroupName = types.StringValue(stat.GroupName)
+	state.Permissions = types.StringValue(stat.Permissions)
+
+	state.Sudo = plan.Sudo
+	state.RunAs = plan.RunAs
+	state.Shell = plan.Shell
+	state.Environment = plan.Environment
+	state.Umask = plan.Umask
+	state.WorkingDir = plan.WorkingDir
+	state.Connection = plan.Connection
+	state.Timeouts = plan.Timeouts
 
 	// Set state to fully populated data
 	diags = resp.State.Set(ctx, state)
@@ -220,34 +279,52 @@ func (r *folderResource) Read(ctx context.Context, req resource.ReadRequest, res
 		return
 	}
 
+	if r.clients.Unknown() {
+		// The host is yet to be created, e.g. in the same plan, keep the
+		// state as is
+		return
+	}
+
+	timeout, diags := state.Timeouts.Read(ctx, defaultReadTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, state.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, state.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	path := state.ID.ValueString()
 
 	// Get refreshed folder value from HashiCups
-	dirExists, err := r.client.dirExists(path)
+	stat, err := client.StatAll(ctx, path, sudo)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error Reading remote folder",
-			"Could not read remote folder ID "+state.ID.ValueString()+": "+err.Error(),
+			"Could not read remote folder ID "+state.ID.ValueString()+": ",
+			err,
 		)
 		return
 	}
 
-	if !dirExists {
+	if !stat.Exists || stat.Type != FileTypeDirectory {
 		resp.State.RemoveResource(ctx)
 		return
 	}
 
-	group, _ := r.client.ReadFileGroup(path, true)
-	owner, _ := r.client.ReadFileOwner(path, true)
-	groupName, _ := r.client.ReadFileGroupName(path, true)
-	ownerName, _ := r.client.ReadFileOwnerName(path, true)
-	permissions, _ := r.client.ReadFilePermissions(path, true)
-
-	state.Owner = types.Int64Value(parseInt(owner))
-	state.Group = types.Int64Value(parseInt(group))
-	state.OwnerName = types.StringValue(ownerName)
-	state.GroupName = types.StringValue(groupName)
-	state.Permissions = types.StringValue(permissions)
+	state.Owner = types.Int64Value(stat.Owner)
+	state.Group = types.Int64Value(stat.Group)
+	state.OwnerName = types.StringValue(stat.OwnerName)
+	state.GroupName = types.StringValue(stat.GroupName)
+	state.Permissions = types.StringValue(stat.Permissions)
 
 	// Set refreshed state
 	diags = resp.State.Set(ctx, &state)
@@ -269,51 +346,78 @@ func (r *folderResource) Update(ctx context.Context, req resource.UpdateRequest,
 		return
 	}
 
+	timeout, diags := plan.Timeouts.Update(ctx, defaultUpdateTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, plan.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, plan.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	path := state.ID.ValueString()
 
 	var err error
 
 	if !plan.Owner.IsUnknown() && plan.Owner != state.Owner {
-		err = r.client.ChownFile(path, plan.Owner.String(), true)
+		err = client.ChownFile(ctx, path, plan.Owner.String(), sudo)
 	} else if !plan.OwnerName.IsUnknown() && !plan.OwnerName.Equal(state.OwnerName) {
-		err = r.client.ChownFile(path, plan.OwnerName.String(), true)
+		err = client.ChownFile(ctx, path, plan.OwnerName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder user ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	if !plan.Group.IsUnknown() && plan.Group != state.Group {
-		err = r.client.ChgrpFile(path, plan.Group.String(), true)
+		err = client.ChgrpFile(ctx, path, plan.Group.String(), sudo)
 	} else if !plan.GroupName.IsUnknown() && !plan.GroupName.Equal(state.GroupName) {
-		err = r.client.ChgrpFile(path, plan.GroupName.String(), true)
+		err = client.ChgrpFile(ctx, path, plan.GroupName.ValueString(), sudo)
 	}
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error updating folder group ownership",
-			"Could not update, unexpected error: "+err.Error(),
+			"Could not update, unexpected error: ",
+			err,
 		)
 		return
 	}
 
 	state.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
 
-	group, _ := r.client.ReadFileGroup(path, true)
-	owner, _ := r.client.ReadFileOwner(path, true)
-	groupName, _ := r.client.ReadFileGroupName(path, true)
-	ownerName, _ := r.client.ReadFileOwnerName(path, true)
-	permissions, _ := r.client.ReadFilePermissions(path, true)
+	stat, err := client.StatAll(ctx, path, sudo)
+	if err != nil {
+		addCommandError(&resp.Diagnostics, "Error updating folder", "Couldn't read folder after update: ", err)
+		return
+	}
 
-	state.Owner = types.Int64Value(parseInt(owner))
-	state.Group = types.Int64Value(parseInt(group))
-	state.OwnerName = types.StringValue(ownerName)
-	state.GroupName = types.StringValue(groupName)
-	state.Permissions = types.StringValue(permissions)
+	state.Owner = types.Int64Value(stat.Owner)
+	state.Group = types.Int64Value(stat.Group)
+	state.OwnerName = types.StringValue(stat.OwnerName)
+	state.GroupName = types.StringValue(stat.GroupName)
+	state.Permissions = types.StringValue(stat.Permissions)
+	state.Sudo = plan.Sudo
+	state.RunAs = plan.RunAs
+	state.Shell = plan.Shell
+	state.Environment = plan.Environment
+	state.Umask = plan.Umask
+	state.WorkingDir = plan.WorkingDir
+	state.Connection = plan.Connection
+	state.Timeouts = plan.Timeouts
 
-	diags := resp.State.Set(ctx, state)
+	diags = resp.State.Set(ctx, state)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
@@ -330,12 +434,30 @@ func (r *folderResource) Delete(ctx context.Context, req resource.DeleteRequest,
 		return
 	}
 
+	timeout, diags := state.Timeouts.Delete(ctx, defaultDeleteTimeout)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.clients.Get(ctx, state.Connection, path.Root("conn"), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	client, sudo := resourceClient(ctx, client, state.commandSettings(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	// Delete existing order
-	err := r.client.DeleteFolder(state.ID.ValueString(), true)
+	err := client.DeleteFolder(ctx, state.ID.ValueString(), sudo)
 	if err != nil {
-		resp.Diagnostics.AddError(
+		addCommandError(&resp.Diagnostics,
 			"Error Deleting HashiCups Order",
-			"Could not delete order, unexpected error: "+err.Error(),
+			"Could not delete order, unexpected error: ",
+			err,
 		)
 		return
 	}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// _objectValue builds an object of typ with values, the other attributes
+// being unknown if computed tells so, null otherwise.
+func _objectValue(typ tftypes.Object, values map[string]tftypes.Value, computed func(name string) bool) tftypes.Value {
+	attributes := map[string]tftypes.Value{}
+	for name, attributeType := range typ.AttributeTypes {
+		switch value, ok := values[name]; {
+		case ok:
+			attributes[name] = value
+		case computed(name):
+			attributes[name] = tftypes.NewValue(attributeType, tftypes.UnknownValue)
+		default:
+			attributes[name] = tftypes.NewValue(attributeType, nil)
+		}
+	}
+	return tftypes.NewValue(typ, attributes)
+}
+
+func TestFolderCreateSFTP(t *testing.T) {
+	handler, commands := _shellHandler(t)
+	host, _ := _sftpServer(t, handler)
+	clients := _remoteClients()
+	clients.config.Transport = types.StringValue(TransportSFTP)
+	r := &folderResource{clients: clients}
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "folder")
+
+	var schemaResp resource.SchemaResponse
+	r.Schema(ctx, resource.SchemaRequest{}, &schemaResp)
+	schema := schemaResp.Schema
+	typ := schema.Type().TerraformType(ctx).(tftypes.Object)
+	conn := typ.AttributeTypes["conn"].(tftypes.Object)
+	plan := _objectValue(typ, map[string]tftypes.Value{
+		"path": tftypes.NewValue(tftypes.String, dir),
+		"conn": _objectValue(conn, map[string]tftypes.Value{
+			"host":     tftypes.NewValue(tftypes.String, host),
+			"username": tftypes.NewValue(tftypes.String, "root"),
+			"password": tftypes.NewValue(tftypes.String, "secret"),
+		}, func(string) bool { return false }),
+	}, func(name string) bool {
+		attribute, ok := schema.Attributes[name]
+		return ok && attribute.IsComputed()
+	})
+
+	resp := resource.CreateResponse{State: tfsdk.State{Schema: schema, Raw: tftypes.NewValue(typ, nil)}}
+	r.Create(ctx, resource.CreateRequest{Plan: tfsdk.Plan{Schema: schema, Raw: plan}}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("Create failed: %v", resp.Diagnostics)
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("Folder wasn't created: %v", err)
+	}
+	var state folderResourceModel
+	resp.Diagnostics.Append(resp.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() || state.Path.ValueString() != dir || state.Owner.ValueInt64() != int64(os.Getuid()) ||
+		state.Connection == nil || state.Connection.Host.ValueString() != host {
+		t.Errorf("Unexpected state: %+v, %v", state, resp.Diagnostics)
+	}
+	// The folder is created over SFTP, only its attributes are read with stat
+	for _, cmd := range commands() {
+		if strings.HasPrefix(cmd, "mkdir") {
+			t.Errorf("Unexpected command: %s", cmd)
+		}
+	}
+}
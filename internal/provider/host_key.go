@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key verification modes accepted by the `host_key_check` attribute.
+const (
+	HostKeyCheckStrict    = "strict"
+	HostKeyCheckAcceptNew = "accept-new"
+	HostKeyCheckInsecure  = "insecure"
+)
+
+// HostKeyError is returned when the remote host presents a key that is
+// unknown or doesn't match the expected one.
+type HostKeyError struct {
+	Host     string
+	KeyType  string
+	Actual   string
+	Expected []string
+}
+
+func (e HostKeyError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf(
+			"remote host %s presented an unknown %s host key %s. "+
+				"Add it to your known_hosts file or to host_key_fingerprints, "+
+				"or set host_key_check to \"accept-new\" to trust it on first use.",
+			e.Host, e.KeyType, e.Actual,
+		)
+	}
+	return fmt.Sprintf(
+		"remote host %s presented %s host key %s, but expected %s. "+
+			"Someone could be intercepting the connection (man-in-the-middle attack), "+
+			"or the host key has legitimately changed.",
+		e.Host, e.KeyType, e.Actual, strings.Join(e.Expected, ", "),
+	)
+}
+
+// hostKeyVerifier implements ssh.HostKeyCallback against pinned fingerprints
+// and/or a known_hosts file. It keeps the last verification failure so that it
+// can be reported to the user instead of the generic handshake error.
+type hostKeyVerifier struct {
+	mode           string
+	fingerprints   []string
+	knownHostsPath string
+	knownHosts     ssh.HostKeyCallback
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func newHostKeyVerifier(mode string, knownHostsPath string, fingerprints []string) (*hostKeyVerifier, error) {
+	v := &hostKeyVerifier{
+		mode:           mode,
+		knownHostsPath: knownHostsPath,
+	}
+	for _, fingerprint := range fingerprints {
+		v.fingerprints = append(v.fingerprints, normalizeFingerprint(fingerprint))
+	}
+
+	if mode == HostKeyCheckInsecure || knownHostsPath == "" {
+		return v, nil
+	}
+
+	if mode == HostKeyCheckAcceptNew {
+		// known_hosts will be created on first accepted key
+		if _, err := os.Stat(knownHostsPath); errors.Is(err, os.ErrNotExist) {
+			return v, nil
+		}
+	}
+
+	if err := v.loadKnownHosts(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *hostKeyVerifier) loadKnownHosts() error {
+	callback, err := knownhosts.New(v.knownHostsPath)
+	if err != nil {
+		return fmt.Errorf("couldn't load known hosts file %s: %s", v.knownHostsPath, err.Error())
+	}
+	v.knownHosts = callback
+	return nil
+}
+
+// Callback verifies the key presented by the remote host.
+func (v *hostKeyVerifier) Callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	err := v.verify(hostname, remote, key)
+
+	v.mu.Lock()
+	v.lastErr = err
+	v.mu.Unlock()
+
+	return err
+}
+
+// LastError returns the failure of the last verification, if any.
+func (v *hostKeyVerifier) LastError() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.lastErr
+}
+
+func (v *hostKeyVerifier) verify(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if v.mode == HostKeyCheckInsecure {
+		return nil
+	}
+
+	actual := ssh.FingerprintSHA256(key)
+	for _, fingerprint := range v.fingerprints {
+		if fingerprint == actual {
+			return nil
+		}
+	}
+	expected := append([]string{}, v.fingerprints...)
+
+	if v.knownHostsPath != "" {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		var err error
+		if v.knownHosts != nil {
+			err = v.knownHosts(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+		}
+
+		var keyErr *knownhosts.KeyError
+		var revokedErr *knownhosts.RevokedError
+		switch {
+		case errors.As(err, &revokedErr):
+			return fmt.Errorf("remote host %s presented a revoked host key %s", hostname, actual)
+		case err != nil && !errors.As(err, &keyErr):
+			return err
+		case keyErr != nil:
+			for _, want := range keyErr.Want {
+				expected = append(expected, fmt.Sprintf(
+					"%s (%s:%d)", ssh.FingerprintSHA256(want.Key), want.Filename, want.Line,
+				))
+			}
+		}
+
+		// Unknown host, trust it on first use
+		if len(expected) == 0 && v.mode == HostKeyCheckAcceptNew {
+			return v.appendKnownHost(hostname, remote, key)
+		}
+	}
+
+	return HostKeyError{
+		Host:     hostname,
+		KeyType:  key.Type(),
+		Actual:   actual,
+		Expected: expected,
+	}
+}
+
+// appendKnownHost records the key of a new host in the known_hosts file.
+func (v *hostKeyVerifier) appendKnownHost(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && knownhosts.Normalize(remote.String()) != addresses[0] {
+		addresses = append(addresses, knownhosts.Normalize(remote.String()))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(v.knownHostsPath), 0700); err != nil {
+		return fmt.Errorf("couldn't create known hosts directory: %s", err.Error())
+	}
+	f, err := os.OpenFile(v.knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't open known hosts file %s: %s", v.knownHostsPath, err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(knownhosts.Line(addresses, key) + "\n"); err != nil {
+		return fmt.Errorf("couldn't write known hosts file %s: %s", v.knownHostsPath, err.Error())
+	}
+
+	// Reload so that later connections to the same host match the new line
+	return v.loadKnownHosts()
+}
+
+// normalizeFingerprint accepts fingerprints with or without the `SHA256:`
+// prefix, as well as base64 padding.
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimSpace(fingerprint)
+	fingerprint = strings.TrimPrefix(fingerprint, "SHA256:")
+	fingerprint = strings.TrimRight(fingerprint, "=")
+	return "SHA256:" + fingerprint
+}
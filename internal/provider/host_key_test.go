@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func _hostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+var _remoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8022}
+
+func TestHostKeyFingerprintMatch(t *testing.T) {
+	key := _hostKey(t)
+	fingerprint := strings.TrimPrefix(ssh.FingerprintSHA256(key), "SHA256:")
+
+	verifier, _ := newHostKeyVerifier(HostKeyCheckStrict, "", []string{fingerprint})
+	if err := verifier.Callback("localhost:8022", _remoteAddr, key); err != nil {
+		t.Errorf("Pinned fingerprint didn't match: %s", err)
+	}
+}
+
+func TestHostKeyFingerprintMismatch(t *testing.T) {
+	key, other := _hostKey(t), _hostKey(t)
+
+	verifier, _ := newHostKeyVerifier(HostKeyCheckStrict, "", []string{ssh.FingerprintSHA256(other)})
+	err := verifier.Callback("localhost:8022", _remoteAddr, key)
+
+	var hostKeyErr HostKeyError
+	if !errors.As(err, &hostKeyErr) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if verifier.LastError() == nil {
+		t.Errorf("Last error wasn't recorded")
+	}
+	message := err.Error()
+	if !strings.Contains(message, "localhost:8022") ||
+		!strings.Contains(message, ssh.FingerprintSHA256(key)) ||
+		!strings.Contains(message, ssh.FingerprintSHA256(other)) {
+		t.Errorf("Error doesn't name host and fingerprints: %s", message)
+	}
+}
+
+func TestHostKeyStrictUnknownHost(t *testing.T) {
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := newHostKeyVerifier(HostKeyCheckStrict, knownHostsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Callback("localhost:8022", _remoteAddr, _hostKey(t)); err == nil {
+		t.Errorf("Unknown host was accepted in strict mode")
+	}
+}
+
+func TestHostKeyAcceptNew(t *testing.T) {
+	key, other := _hostKey(t), _hostKey(t)
+	knownHostsPath := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	verifier, err := newHostKeyVerifier(HostKeyCheckAcceptNew, knownHostsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Callback("localhost:8022", _remoteAddr, key); err != nil {
+		t.Fatalf("New host wasn't accepted: %s", err)
+	}
+	if err := verifier.Callback("localhost:8022", _remoteAddr, key); err != nil {
+		t.Errorf("Recorded host wasn't accepted: %s", err)
+	}
+
+	err = verifier.Callback("localhost:8022", _remoteAddr, other)
+	var hostKeyErr HostKeyError
+	if !errors.As(err, &hostKeyErr) || len(hostKeyErr.Expected) != 1 {
+		t.Errorf("Changed host key wasn't rejected: %v", err)
+	}
+}
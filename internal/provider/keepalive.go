@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// keepAliveCountMax is how many keepalive intervals a reply may take before
+	// the connection is considered dead, like OpenSSH's ServerAliveCountMax.
+	keepAliveCountMax = 3
+
+	// keepAliveTimeout bounds the liveness check of a connection suspected dead.
+	keepAliveTimeout = 15 * time.Second
+)
+
+// keepAlive sends a keepalive request over client, and waits for the reply
+// until timeout. Servers reply even to requests they don't know, which is
+// enough to prove the transport is up.
+func keepAlive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no keepalive reply within %s", timeout)
+	}
+}
+
+// isClosed tells whether ch is closed, without blocking.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
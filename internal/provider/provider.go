@@ -3,10 +3,15 @@ package provider
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
@@ -45,15 +50,67 @@ func (p *hashicupsProvider) Metadata(_ context.Context, _ provider.MetadataReque
 
 // hashicupsProviderModel maps provider schema data to a Go type.
 type hashicupsProviderModel struct {
-	Host             types.String `tfsdk:"host"`
-	Username         types.String `tfsdk:"username"`
-	Password         types.String `tfsdk:"password"`
-	PasswordEnvVar   types.String `tfsdk:"password_env_var"`
-	PrivateKey       types.String `tfsdk:"private_key"`
-	PrivateKeyPath   types.String `tfsdk:"private_key_path"`
-	PrivateKeyEnvVar types.String `tfsdk:"private_key_env_var"`
-	Sudo             types.Bool   `tfsdk:"sudo"`
-	MaxSessions      types.Int64  `tfsdk:"max_sessions"`
+	Host                types.String `tfsdk:"host"`
+	Username            types.String `tfsdk:"username"`
+	Password            types.String `tfsdk:"password"`
+	PasswordEnvVar      types.String `tfsdk:"password_env_var"`
+	PrivateKey          types.String `tfsdk:"private_key"`
+	PrivateKeyPath      types.String `tfsdk:"private_key_path"`
+	PrivateKeyEnvVar    types.String `tfsdk:"private_key_env_var"`
+	Sudo                types.Bool   `tfsdk:"sudo"`
+	MaxSessions         types.Int64  `tfsdk:"max_sessions"`
+	MaxConnections      types.Int64  `tfsdk:"max_connections"`
+	Transport           types.String `tfsdk:"transport"`
+	PersistentShell     types.Bool   `tfsdk:"persistent_shell"`
+	HostKeyCheck        types.String `tfsdk:"host_key_check"`
+	KnownHostsPath      types.String `tfsdk:"known_hosts_path"`
+	HostKeyFingerprints types.List   `tfsdk:"host_key_fingerprints"`
+	Agent               types.Bool   `tfsdk:"agent"`
+	AgentSocket         types.String `tfsdk:"agent_socket"`
+	Passphrase          types.String `tfsdk:"private_key_passphrase"`
+	PassphraseEnvVar    types.String `tfsdk:"private_key_passphrase_env_var"`
+	Certificate         types.String `tfsdk:"certificate"`
+	CertificatePath     types.String `tfsdk:"certificate_path"`
+	KeyboardInteractive types.Map    `tfsdk:"keyboard_interactive"`
+	Proxy               types.String `tfsdk:"proxy"`
+	SSHConfigFile       types.String `tfsdk:"ssh_config_file"`
+	ConnectTimeout      types.String `tfsdk:"connect_timeout"`
+	ConnectRetryTimeout types.String `tfsdk:"connect_retry_timeout"`
+	KeepAliveInterval   types.String `tfsdk:"keepalive_interval"`
+	CommandTimeout      types.String `tfsdk:"command_timeout"`
+	Shell               types.String `tfsdk:"shell"`
+	Environment         types.Map    `tfsdk:"environment"`
+	Umask               types.String `tfsdk:"umask"`
+	WorkingDir          types.String `tfsdk:"working_dir"`
+	CredentialCommand   types.List   `tfsdk:"credential_command"`
+	Ciphers             types.List   `tfsdk:"ciphers"`
+	KeyExchanges        types.List   `tfsdk:"key_exchanges"`
+	MACs                types.List   `tfsdk:"macs"`
+	HostKeyAlgorithms   types.List   `tfsdk:"host_key_algorithms"`
+	JumpHosts           types.List   `tfsdk:"jump_host"`
+	Become              *becomeModel `tfsdk:"become"`
+}
+
+// hostModel maps a jump_host block, or the conn block of a resource.
+type hostModel struct {
+	Host                types.String `tfsdk:"host"`
+	Username            types.String `tfsdk:"username"`
+	Password            types.String `tfsdk:"password"`
+	PrivateKey          types.String `tfsdk:"private_key"`
+	PrivateKeyPath      types.String `tfsdk:"private_key_path"`
+	Passphrase          types.String `tfsdk:"private_key_passphrase"`
+	CertificatePath     types.String `tfsdk:"certificate_path"`
+	Agent               types.Bool   `tfsdk:"agent"`
+	AgentSocket         types.String `tfsdk:"agent_socket"`
+	HostKeyFingerprints types.List   `tfsdk:"host_key_fingerprints"`
+}
+
+// becomeModel maps the become block.
+type becomeModel struct {
+	Method     types.String `tfsdk:"method"`
+	User       types.String `tfsdk:"become_user"`
+	Password   types.String `tfsdk:"become_password"`
+	RequireTTY types.Bool   `tfsdk:"requiretty"`
 }
 
 // Schema defines the provider-level schema for configuration data.
@@ -61,8 +118,9 @@ func (p *hashicupsProvider) Schema(_ context.Context, _ provider.SchemaRequest,
 	resp.Schema = schema.Schema{
 		Attributes: map[string]schema.Attribute{
 			"host": schema.StringAttribute{
-				Description: "Remote host to connect. example: `localhost:8022`.",
-				Required:    true,
+				Description: "Remote host to connect. example: `localhost:8022`. " +
+					"Can be omitted if every resource has a `conn` block",
+				Optional: true,
 			},
 			"username": schema.StringAttribute{
 				Description: "SSH user. Default is current user",
@@ -91,14 +149,240 @@ func (p *hashicupsProvider) Schema(_ context.Context, _ provider.SchemaRequest,
 				Description: "Env var with private key",
 				Optional:    true,
 			},
+			"private_key_passphrase": schema.StringAttribute{
+				Description: "Passphrase of an encrypted private key",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"private_key_passphrase_env_var": schema.StringAttribute{
+				Description: "Env var with the passphrase of an encrypted private key",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"certificate": schema.StringAttribute{
+				Description: "OpenSSH user certificate signed for the private key, in `authorized_keys` format",
+				Optional:    true,
+			},
+			"certificate_path": schema.StringAttribute{
+				Description: "Path to an OpenSSH user certificate signed for the private key. example: `~/.ssh/id_ed25519-cert.pub`",
+				Optional:    true,
+			},
+			"keyboard_interactive": schema.MapAttribute{
+				Description: "Answers to keyboard-interactive authentication prompts, keyed by prompt regex. " +
+					"Hidden prompts matching no regex are answered with the password. example: `{ \"(?i)verification code\" = \"123456\" }`",
+				ElementType: types.StringType,
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"agent": schema.BoolAttribute{
+				Description: "Whether to authenticate with the identities of the SSH agent. Default: false",
+				Optional:    true,
+			},
+			"agent_socket": schema.StringAttribute{
+				Description: "Path to the SSH agent socket. Default is the `SSH_AUTH_SOCK` env var",
+				Optional:    true,
+			},
+			"credential_command": schema.ListAttribute{
+				Description: "Program and arguments printing the credentials as a JSON document, with optional " +
+					"`username`, `password`, `private_key`, `passphrase` and `certificate` fields. It runs once, " +
+					"on first connection. Explicit provider attributes take precedence. example: `[\"secrets\", \"ssh\", \"--json\"]`",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
 			"sudo": schema.BoolAttribute{
-				Description: "Whether commands should be executed as sudo or not. Default: false",
+				Description: "Whether commands should be executed as sudo or not, same as an empty `become` block. Default: false",
 				Optional:    true,
 			},
 			"max_sessions": schema.Int64Attribute{
 				Description: "SSH max concurrent sessions. Default: 5",
 				Optional:    true,
 			},
+			"max_connections": schema.Int64Attribute{
+				Description: "Max SSH connections to a host, each with up to `max_sessions` sessions. " +
+					"Connections are opened when the others are full, and closed after a minute without sessions. Default: 1",
+				Optional: true,
+			},
+			"transport": schema.StringAttribute{
+				Description: "How files are transferred and managed: `shell` runs commands such as `cat` and `chmod`, " +
+					"`sftp` uses the SFTP subsystem, `scp` transfers file contents with the SCP protocol and runs shell commands otherwise, " +
+					"and `auto` uses SFTP if the server has it, SCP if it has `scp`, shell commands otherwise. " +
+					"SFTP isn't used for operations with sudo, nor for owners and groups given by name. Default: `shell`",
+				Optional: true,
+			},
+			"persistent_shell": schema.BoolAttribute{
+				Description: "Whether to run commands in long-lived helper shells, kept by each connection, instead of a " +
+					"new session per command. Commands run with sudo still run in their own session, so that their output " +
+					"isn't spooled to the temporary files of the helper shells. Default: false",
+				Optional: true,
+			},
+			"connect_timeout": schema.StringAttribute{
+				Description: "Timeout of each connection attempt, authentication included. example: `30s`. Default: no timeout",
+				Optional:    true,
+			},
+			"connect_retry_timeout": schema.StringAttribute{
+				Description: "How long to retry connections failing at the network level, with exponential backoff, e.g. while the remote host is booting. " +
+					"Host key, authentication and algorithm negotiation failures aren't retried. example: `5m`. Default: no retry",
+				Optional: true,
+			},
+			"keepalive_interval": schema.StringAttribute{
+				Description: "Interval between keepalive requests, to detect a lost connection and reconnect. " +
+					"`0` disables keepalives. Default: `30s`",
+				Optional: true,
+			},
+			"command_timeout": schema.StringAttribute{
+				Description: "Timeout of each remote command. Past it, the command is stopped and the operation fails. " +
+					"example: `5m`. Default: no timeout",
+				Optional: true,
+			},
+			"shell": schema.StringAttribute{
+				Description: "Shell running commands with `-c`, for users whose login shell isn't POSIX, e.g. fish or a restricted shell. " +
+					"example: `sh`. Default is the login shell",
+				Optional: true,
+			},
+			"environment": schema.MapAttribute{
+				Description: "Environment variables of commands, e.g. `LANG` or `PATH`. " +
+					"They are sent with the SSH session if the server accepts them, exported by the command otherwise",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"umask": schema.StringAttribute{
+				Description: "Umask of commands, in octal. example: `022`. Default is the umask of the remote user",
+				Optional:    true,
+			},
+			"working_dir": schema.StringAttribute{
+				Description: "Absolute path of the directory commands run in. Default is the home directory of the remote user",
+				Optional:    true,
+			},
+			"ssh_config_file": schema.StringAttribute{
+				Description: "Path to an OpenSSH client config file, example: `~/.ssh/config`. If set, `host` can be a `Host` alias, " +
+					"resolved to its `HostName`, `Port`, `User`, first `IdentityFile`, `ProxyJump` and `StrictHostKeyChecking`. " +
+					"Explicit provider attributes take precedence, and `IdentityFile` is ignored if a private key or the agent is set. " +
+					"Default: not used",
+				Optional: true,
+			},
+			"proxy": schema.StringAttribute{
+				Description: "Proxy to reach the remote host, or the first jump host: `socks5://[user:password@]host:port` " +
+					"or `http://[user:password@]host:port` for HTTP CONNECT proxies. Default is the `ALL_PROXY` env var",
+				Optional:  true,
+				Sensitive: true,
+			},
+			"ciphers": schema.ListAttribute{
+				Description: "Ciphers to offer, in order of preference, for every connection including jump hosts. " +
+					"example: `[\"aes128-ctr\", \"aes128-cbc\"]`. Default: the ssh package defaults",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"key_exchanges": schema.ListAttribute{
+				Description: "Key exchange algorithms to offer, in order of preference. " +
+					"example: `[\"diffie-hellman-group14-sha1\"]`. Default: the ssh package defaults",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"macs": schema.ListAttribute{
+				Description: "MAC algorithms to offer, in order of preference. example: `[\"hmac-sha1\"]`. Default: the ssh package defaults",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"host_key_algorithms": schema.ListAttribute{
+				Description: "Host key algorithms to accept, in order of preference. example: `[\"ssh-rsa\"]`. Default: the ssh package defaults",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"host_key_check": schema.StringAttribute{
+				Description: "Host key verification mode: `strict`, `accept-new` (trust and record unknown hosts, reject changed keys) " +
+					"or `insecure`. Default: `strict` if `known_hosts_path` or `host_key_fingerprints` is set, `insecure` otherwise",
+				Optional: true,
+			},
+			"known_hosts_path": schema.StringAttribute{
+				Description: "Path to a known_hosts file. Default: `~/.ssh/known_hosts` when `host_key_check` is `strict` or `accept-new` " +
+					"and no `host_key_fingerprints` are set",
+				Optional: true,
+			},
+			"host_key_fingerprints": schema.ListAttribute{
+				Description: "Pinned SHA256 host key fingerprints, as printed by `ssh-keygen -lf`. " +
+					"example: `SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8`",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"become": schema.SingleNestedBlock{
+				Description: "Privilege escalation of every remote command, taking precedence over `sudo`.",
+				Attributes: map[string]schema.Attribute{
+					"method": schema.StringAttribute{
+						Description: "Escalation method: `sudo`, `doas` or `su`. Default: `sudo`",
+						Optional:    true,
+					},
+					"become_user": schema.StringAttribute{
+						Description: "User to run commands as. Default: `root`",
+						Optional:    true,
+					},
+					"become_password": schema.StringAttribute{
+						Description: "Password answering the escalation prompt, sent over stdin with `sudo`, " +
+							"or a pseudo terminal with `doas`, `su` or `requiretty`. " +
+							"Without it, commands fail instead of waiting for a password",
+						Optional:  true,
+						Sensitive: true,
+					},
+					"requiretty": schema.BoolAttribute{
+						Description: "Run `sudo` in a pseudo terminal, for hosts with the `requiretty` sudoers option. Default: false",
+						Optional:    true,
+					},
+				},
+			},
+			"jump_host": schema.ListNestedBlock{
+				Description: "SSH servers to tunnel the connection through, in order, like OpenSSH's `ProxyJump`. " +
+					"The first one is dialed directly, each next one through the previous.",
+				NestedObject: schema.NestedBlockObject{
+					Attributes: map[string]schema.Attribute{
+						"host": schema.StringAttribute{
+							Description: "Jump host to connect. example: `bastion.example.com:22`.",
+							Required:    true,
+						},
+						"username": schema.StringAttribute{
+							Description: "SSH user. Default is current user",
+							Optional:    true,
+						},
+						"password": schema.StringAttribute{
+							Description: "SSH password.",
+							Optional:    true,
+							Sensitive:   true,
+						},
+						"private_key": schema.StringAttribute{
+							Description: "SSH private key",
+							Optional:    true,
+							Sensitive:   true,
+						},
+						"private_key_path": schema.StringAttribute{
+							Description: "Path to SSH private key",
+							Optional:    true,
+						},
+						"private_key_passphrase": schema.StringAttribute{
+							Description: "Passphrase of an encrypted private key",
+							Optional:    true,
+							Sensitive:   true,
+						},
+						"certificate_path": schema.StringAttribute{
+							Description: "Path to an OpenSSH user certificate signed for the private key",
+							Optional:    true,
+						},
+						"agent": schema.BoolAttribute{
+							Description: "Whether to authenticate with the identities of the SSH agent. Default: false",
+							Optional:    true,
+						},
+						"agent_socket": schema.StringAttribute{
+							Description: "Path to the SSH agent socket. Default is the `SSH_AUTH_SOCK` env var",
+							Optional:    true,
+						},
+						"host_key_fingerprints": schema.ListAttribute{
+							Description: "Pinned SHA256 host key fingerprints of the jump host. " +
+								"The provider `host_key_check` and `known_hosts_path` apply to jump hosts too",
+							ElementType: types.StringType,
+							Optional:    true,
+						},
+					},
+				},
+			},
 		},
 	}
 }
@@ -113,28 +397,227 @@ func (p *hashicupsProvider) Configure(ctx context.Context, req provider.Configur
 		return
 	}
 
+	// Connections are opened on first use, so that the provider can be
+	// configured with values unknown until apply, such as the address of an
+	// instance yet to be created.
+	clients := &remoteClients{
+		provider:    p,
+		config:      config,
+		configKnown: req.Config.Raw.IsFullyKnown(),
+		clients:     map[string]*cachedClient{},
+	}
+
+	// Make the clients available during DataSource and Resource
+	// type Configure methods.
+	resp.DataSourceData = clients
+	resp.ResourceData = clients
+}
+
+// applyCredentialCommand runs the credential command, and fills the
+// credentials that aren't set explicitly.
+func (p *hashicupsProvider) applyCredentialCommand(ctx context.Context, config *hashicupsProviderModel, diags *diag.Diagnostics) {
+	var command []string
+	diags.Append(config.CredentialCommand.ElementsAs(ctx, &command, false)...)
+	if diags.HasError() {
+		return
+	}
+
+	credentials, err := RunCredentialCommand(ctx, command)
+	if err != nil {
+		diags.AddAttributeError(
+			path.Root("credential_command"),
+			"Credential command error",
+			err.Error(),
+		)
+		return
+	}
+
+	if config.Username.IsNull() && credentials.Username != "" {
+		config.Username = types.StringValue(credentials.Username)
+	}
+	if config.Password.IsNull() && config.PasswordEnvVar.IsNull() && credentials.Password != "" {
+		config.Password = types.StringValue(credentials.Password)
+	}
+	if config.PrivateKey.IsNull() && config.PrivateKeyPath.IsNull() && config.PrivateKeyEnvVar.IsNull() && credentials.PrivateKey != "" {
+		config.PrivateKey = types.StringValue(credentials.PrivateKey)
+	}
+	if config.Passphrase.IsNull() && config.PassphraseEnvVar.IsNull() && credentials.Passphrase != "" {
+		config.Passphrase = types.StringValue(credentials.Passphrase)
+	}
+	if config.Certificate.IsNull() && config.CertificatePath.IsNull() && credentials.Certificate != "" {
+		config.Certificate = types.StringValue(credentials.Certificate)
+	}
+}
+
+// applySSHConfig resolves the host alias from the OpenSSH config file, and
+// fills the attributes that aren't set explicitly.
+func (p *hashicupsProvider) applySSHConfig(config *hashicupsProviderModel, jumpHosts *[]hostModel, diags *diag.Diagnostics) {
+	sshConfig, err := LoadSSHConfig(expandPath(config.SSHConfigFile.ValueString()))
+	if err != nil {
+		diags.AddAttributeError(
+			path.Root("ssh_config_file"),
+			"SSH config reading error",
+			fmt.Sprintf("couldn't read SSH config: %s", err.Error()),
+		)
+		return
+	}
+
+	resolve := func(destination string) *SSHConfigHost {
+		alias, port, err := net.SplitHostPort(destination)
+		if err != nil {
+			alias, port = destination, ""
+		}
+		host, err := sshConfig.Host(alias)
+		if err != nil {
+			diags.AddAttributeError(
+				path.Root("ssh_config_file"),
+				"SSH config error",
+				err.Error(),
+			)
+			return nil
+		}
+		if port != "" {
+			host.Port = port
+		}
+		return host
+	}
+
+	host := resolve(config.Host.ValueString())
+	if host == nil {
+		return
+	}
+
+	// Like OpenSSH, identity files are only a fallback when no key is given
+	// and the agent isn't used
+	useIdentityFile := config.PrivateKey.IsNull() && config.PrivateKeyPath.IsNull() &&
+		config.PrivateKeyEnvVar.IsNull() && !config.Agent.ValueBool()
+
+	config.Host = types.StringValue(host.Address())
+	if config.Username.IsNull() && host.User != "" {
+		config.Username = types.StringValue(host.User)
+	}
+	if useIdentityFile && len(host.IdentityFiles) > 0 {
+		config.PrivateKeyPath = types.StringValue(host.IdentityFiles[0])
+	}
+	if config.HostKeyCheck.IsNull() && host.HostKeyCheck() != "" {
+		config.HostKeyCheck = types.StringValue(host.HostKeyCheck())
+	}
+
+	if len(*jumpHosts) > 0 {
+		return
+	}
+	// Jump hosts resolved from ProxyJump authenticate like the remote host,
+	// unless they have their own identity file and no key source is set
+	for _, jump := range host.ProxyJump {
+		user, jumpHostName, jumpPort := ParseJump(jump)
+		jumpHost := resolve(jumpHostName)
+		if jumpHost == nil {
+			return
+		}
+		if jumpPort != "" {
+			jumpHost.Port = jumpPort
+		}
+		if user == "" {
+			user = jumpHost.User
+		}
+
+		jumpHostModel := hostModel{
+			Host:            types.StringValue(jumpHost.Address()),
+			Password:        config.Password,
+			PrivateKey:      config.PrivateKey,
+			PrivateKeyPath:  config.PrivateKeyPath,
+			Passphrase:      config.Passphrase,
+			CertificatePath: config.CertificatePath,
+			Agent:           config.Agent,
+			AgentSocket:     config.AgentSocket,
+		}
+		if user != "" {
+			jumpHostModel.Username = types.StringValue(user)
+		}
+		if useIdentityFile && len(jumpHost.IdentityFiles) > 0 {
+			jumpHostModel.PrivateKey = types.StringNull()
+			jumpHostModel.PrivateKeyPath = types.StringValue(jumpHost.IdentityFiles[0])
+		}
+		*jumpHosts = append(*jumpHosts, jumpHostModel)
+	}
+}
+
+// sshCredentials gathers the authentication attributes of a host, shared by
+// the provider and its jump hosts.
+type sshCredentials struct {
+	Username            types.String
+	Password            types.String
+	PasswordEnvVar      types.String
+	PrivateKey          types.String
+	PrivateKeyPath      types.String
+	PrivateKeyEnvVar    types.String
+	Passphrase          types.String
+	PassphraseEnvVar    types.String
+	Certificate         types.String
+	CertificatePath     types.String
+	KeyboardInteractive types.Map
+	Agent               types.Bool
+	AgentSocket         types.String
+}
+
+func (m hashicupsProviderModel) credentials() sshCredentials {
+	return sshCredentials{
+		Username:            m.Username,
+		Password:            m.Password,
+		PasswordEnvVar:      m.PasswordEnvVar,
+		PrivateKey:          m.PrivateKey,
+		PrivateKeyPath:      m.PrivateKeyPath,
+		PrivateKeyEnvVar:    m.PrivateKeyEnvVar,
+		Passphrase:          m.Passphrase,
+		PassphraseEnvVar:    m.PassphraseEnvVar,
+		Certificate:         m.Certificate,
+		CertificatePath:     m.CertificatePath,
+		KeyboardInteractive: m.KeyboardInteractive,
+		Agent:               m.Agent,
+		AgentSocket:         m.AgentSocket,
+	}
+}
+
+func (m hostModel) credentials() sshCredentials {
+	return sshCredentials{
+		Username:        m.Username,
+		Password:        m.Password,
+		PrivateKey:      m.PrivateKey,
+		PrivateKeyPath:  m.PrivateKeyPath,
+		Passphrase:      m.Passphrase,
+		CertificatePath: m.CertificatePath,
+		Agent:           m.Agent,
+		AgentSocket:     m.AgentSocket,
+	}
+}
+
+// sshClientConfig builds a SSH client config authenticating with creds, read
+// from the attributes under attributes. The SSH agent, if used, is taken from
+// agents. The certificate, if any, is returned to describe it when
+// authentication fails.
+func (p *hashicupsProvider) sshClientConfig(ctx context.Context, creds sshCredentials, agents *sshAgents, attributes path.Path, diags *diag.Diagnostics) (*ssh.ClientConfig, *ssh.Certificate) {
 	var username string
-	if !config.Username.IsNull() {
-		username = config.Username.ValueString()
+	if !creds.Username.IsNull() {
+		username = creds.Username.ValueString()
 	} else {
 		// Default value is current user
 		currentUser, _ := user.Current()
 		username = currentUser.Username
 	}
 
-	// Create a new remote client
-	clientConfig := ssh.ClientConfig{
-		User:            username,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	clientConfig := &ssh.ClientConfig{
+		User: username,
 	}
 
-	if !config.Password.IsNull() {
-		clientConfig.Auth = append(clientConfig.Auth, ssh.Password(config.Password.ValueString()))
-	} else if !config.PasswordEnvVar.IsNull() {
-		password := os.Getenv(config.PasswordEnvVar.ValueString())
+	var password string
+	if !creds.Password.IsNull() {
+		password = creds.Password.ValueString()
+		clientConfig.Auth = append(clientConfig.Auth, ssh.Password(password))
+	} else if !creds.PasswordEnvVar.IsNull() {
+		password = os.Getenv(creds.PasswordEnvVar.ValueString())
 		if password == "" {
-			resp.Diagnostics.AddAttributeWarning(
-				path.Root("password_env_var"),
+			diags.AddAttributeWarning(
+				attributes.AtName("password_env_var"),
 				"Empty password ENV var",
 				"",
 			)
@@ -142,60 +625,251 @@ func (p *hashicupsProvider) Configure(ctx context.Context, req provider.Configur
 		clientConfig.Auth = append(clientConfig.Auth, ssh.Password(password))
 	}
 
-	if !config.PrivateKey.IsNull() {
-		signer, err := ssh.ParsePrivateKey([]byte(config.PrivateKey.ValueString()))
+	if !creds.KeyboardInteractive.IsNull() {
+		answers := map[string]string{}
+		diags.Append(creds.KeyboardInteractive.ElementsAs(ctx, &answers, false)...)
+
+		challenge, err := NewKeyboardInteractiveChallenge(answers, password)
+		if err != nil {
+			diags.AddAttributeError(
+				attributes.AtName("keyboard_interactive"),
+				"Keyboard-interactive configuration error",
+				err.Error(),
+			)
+		}
+		clientConfig.Auth = append(clientConfig.Auth, ssh.KeyboardInteractive(challenge))
+	}
+
+	// All public keys are offered by a single auth method, as the ssh package
+	// only attempts each method once.
+	var passphrase string
+	if !creds.Passphrase.IsNull() {
+		passphrase = creds.Passphrase.ValueString()
+	} else if !creds.PassphraseEnvVar.IsNull() {
+		passphrase = os.Getenv(creds.PassphraseEnvVar.ValueString())
+		if passphrase == "" {
+			diags.AddAttributeWarning(
+				attributes.AtName("private_key_passphrase_env_var"),
+				"Empty private key passphrase ENV var",
+				"",
+			)
+		}
+	}
+
+	var signers []ssh.Signer
+	if !creds.PrivateKey.IsNull() {
+		signer, err := ParsePrivateKey([]byte(creds.PrivateKey.ValueString()), passphrase)
 		if err != nil {
-			resp.Diagnostics.AddAttributeError(
-				path.Root("private_key"),
+			diags.AddAttributeError(
+				attributes.AtName("private_key"),
 				"Private key parsing error",
-				fmt.Sprintf("couldn't create a ssh client config from private key: %s", err.Error()),
+				privateKeyError(err),
 			)
 		}
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
-	} else if !config.PrivateKeyPath.IsNull() {
-		content, err := os.ReadFile(config.PrivateKeyPath.ValueString())
+		signers = append(signers, signer)
+	} else if !creds.PrivateKeyPath.IsNull() {
+		content, err := os.ReadFile(creds.PrivateKeyPath.ValueString())
 		if err != nil {
-			resp.Diagnostics.AddAttributeError(
-				path.Root("private_key_path"),
+			diags.AddAttributeError(
+				attributes.AtName("private_key_path"),
 				"Private key path reading error",
 				fmt.Sprintf("couldn't read private key: %s", err.Error()),
 			)
 		}
-		signer, err := ssh.ParsePrivateKey(content)
+		signer, err := ParsePrivateKey(content, passphrase)
 		if err != nil {
-			resp.Diagnostics.AddAttributeError(
-				path.Root("private_key_path"),
+			diags.AddAttributeError(
+				attributes.AtName("private_key_path"),
 				"Private key parsing error",
-				fmt.Sprintf("couldn't create a ssh client config from private key: %s", err.Error()),
+				privateKeyError(err),
 			)
 		}
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
+		signers = append(signers, signer)
+	} else if !creds.PrivateKeyEnvVar.IsNull() {
+		content := os.Getenv(creds.PrivateKeyEnvVar.ValueString())
+		if content == "" {
+			diags.AddAttributeError(
+				attributes.AtName("private_key_env_var"),
+				"Empty private key ENV var",
+				fmt.Sprintf("The %s env var is empty or not set.", creds.PrivateKeyEnvVar.ValueString()),
+			)
+		} else {
+			signer, err := ParsePrivateKey([]byte(content), passphrase)
+			if err != nil {
+				diags.AddAttributeError(
+					attributes.AtName("private_key_env_var"),
+					"Private key parsing error",
+					privateKeyError(err),
+				)
+			}
+			signers = append(signers, signer)
+		}
 	}
 
-	if resp.Diagnostics.HasError() {
-		return
+	var certificate *ssh.Certificate
+	if !creds.Certificate.IsNull() || !creds.CertificatePath.IsNull() {
+		attribute := attributes.AtName("certificate")
+		content := []byte(creds.Certificate.ValueString())
+		if creds.Certificate.IsNull() {
+			attribute = attributes.AtName("certificate_path")
+			var err error
+			content, err = os.ReadFile(expandPath(creds.CertificatePath.ValueString()))
+			if err != nil {
+				diags.AddAttributeError(
+					attribute,
+					"Certificate path reading error",
+					fmt.Sprintf("couldn't read certificate: %s", err.Error()),
+				)
+			}
+		}
+
+		var err error
+		certificate, err = ParseCertificate(content)
+		if err != nil {
+			diags.AddAttributeError(
+				attribute,
+				"Certificate parsing error",
+				fmt.Sprintf("couldn't parse OpenSSH certificate: %s", err.Error()),
+			)
+		} else if len(signers) == 0 || signers[0] == nil {
+			diags.AddAttributeError(
+				attribute,
+				"Certificate without private key",
+				"A certificate requires the matching `private_key`, `private_key_path` or `private_key_env_var`.",
+			)
+		} else {
+			signers[0], err = ssh.NewCertSigner(certificate, signers[0])
+			if err != nil {
+				diags.AddAttributeError(
+					attribute,
+					"Certificate error",
+					fmt.Sprintf("couldn't use certificate with private key: %s", err.Error()),
+				)
+			}
+		}
+	}
+
+	var sshAgent *SSHAgent
+	if creds.Agent.ValueBool() {
+		var empty bool
+		var err error
+		sshAgent, err = agents.Get(expandPath(creds.AgentSocket.ValueString()))
+		if err == nil {
+			empty, err = sshAgent.Empty()
+		}
+		switch {
+		case err != nil:
+			diags.AddAttributeError(
+				attributes.AtName("agent"),
+				"SSH agent error",
+				err.Error(),
+			)
+		case empty && (len(clientConfig.Auth) > 0 || len(signers) > 0):
+			diags.AddAttributeWarning(
+				attributes.AtName("agent"),
+				"Empty SSH agent",
+				fmt.Sprintf("The SSH agent at %s holds no identities, only the other authentication methods are tried.", sshAgent.socket),
+			)
+		case empty:
+			diags.AddAttributeError(
+				attributes.AtName("agent"),
+				"Empty SSH agent",
+				fmt.Sprintf("The SSH agent at %s holds no identities, and no other authentication method is set.", sshAgent.socket),
+			)
+		}
+	}
+
+	if len(signers) > 0 || sshAgent != nil {
+		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if sshAgent == nil {
+				return signers, nil
+			}
+			identities, err := sshAgent.Signers()
+			if err != nil {
+				return nil, err
+			}
+			return append(append([]ssh.Signer{}, signers...), identities...), nil
+		}))
+	}
+
+	return clientConfig, certificate
+}
+
+// hostKeyVerifier builds the host key verifier of a host from the provider host
+// key attributes and the host pinned fingerprints, found under attributes.
+func (p *hashicupsProvider) hostKeyVerifier(ctx context.Context, config hashicupsProviderModel, hostKeyFingerprints types.List, attributes path.Path, diags *diag.Diagnostics) *hostKeyVerifier {
+	var fingerprints []string
+	if !hostKeyFingerprints.IsNull() {
+		diags.Append(hostKeyFingerprints.ElementsAs(ctx, &fingerprints, false)...)
+		if diags.HasError() {
+			return nil
+		}
 	}
 
-	maxSessions := int64(5) // Default value
-	if !config.MaxSessions.IsNull() {
-		maxSessions = config.MaxSessions.ValueInt64()
+	knownHostsPath := expandPath(config.KnownHostsPath.ValueString())
+
+	mode := config.HostKeyCheck.ValueString()
+	if config.HostKeyCheck.IsNull() {
+		mode = HostKeyCheckInsecure
+		if knownHostsPath != "" || len(fingerprints) > 0 {
+			mode = HostKeyCheckStrict
+		}
 	}
 
-	client, err := NewRemoteClient(config.Host.ValueString(), &clientConfig, config.Sudo.ValueBool(), int(maxSessions))
+	switch mode {
+	case HostKeyCheckStrict, HostKeyCheckAcceptNew:
+		if knownHostsPath == "" && (len(fingerprints) == 0 || mode == HostKeyCheckAcceptNew) {
+			knownHostsPath = expandPath("~/.ssh/known_hosts")
+		}
+	case HostKeyCheckInsecure:
+	default:
+		diags.AddAttributeError(
+			path.Root("host_key_check"),
+			"Invalid host key check mode",
+			fmt.Sprintf("Expected one of %q, %q or %q, got %q.", HostKeyCheckStrict, HostKeyCheckAcceptNew, HostKeyCheckInsecure, mode),
+		)
+		return nil
+	}
+
+	verifier, err := newHostKeyVerifier(mode, knownHostsPath, fingerprints)
 	if err != nil {
-		resp.Diagnostics.AddError(
-			"Unable to Create Remote API Client",
-			"An unexpected error occurred when creating the HashiCups API client. "+
-				"If the error is not clear, please contact the provider developers.\n\n"+
-				"HashiCups Client Error: "+err.Error(),
+		diags.AddAttributeError(
+			path.Root("known_hosts_path"),
+			"Known hosts reading error",
+			err.Error(),
 		)
-		return
+		return nil
 	}
+	return verifier
+}
 
-	// Make the HashiCups client available during DataSource and Resource
-	// type Configure methods.
-	resp.DataSourceData = client
-	resp.ResourceData = client
+// parseDuration parses an optional duration attribute, such as `30s` or `5m`.
+// Null is zero.
+func parseDuration(value types.String, attribute path.Path, diags *diag.Diagnostics) time.Duration {
+	if value.IsNull() {
+		return 0
+	}
+	duration, err := time.ParseDuration(value.ValueString())
+	if err != nil {
+		diags.AddAttributeError(
+			attribute,
+			"Invalid duration",
+			fmt.Sprintf("Expected a duration such as `30s` or `5m`: %s", err.Error()),
+		)
+	}
+	return duration
+}
+
+// expandPath replaces a leading `~` with the home directory of the current user.
+func expandPath(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
 }
 
 // DataSources defines the data sources implemented in the provider.
@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// NewProxyDialer returns a dialer connecting through the proxy at rawURL.
+// Supported schemes are `socks5` (or `socks5h`) and `http`, for HTTP CONNECT
+// proxies. Credentials can be given as `user:password@` in the URL.
+func NewProxyDialer(rawURL string) (proxy.Dialer, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %s", err.Error())
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		return proxy.FromURL(proxyURL, proxy.Direct)
+	case "http":
+		address := proxyURL.Host
+		if proxyURL.Port() == "" {
+			address = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+		return &httpConnectDialer{address: address, user: proxyURL.User}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected `socks5` or `http`", proxyURL.Scheme)
+	}
+}
+
+// ProxyFromEnvironment returns the proxy URL set in the ALL_PROXY env var, or
+// its lowercase variant.
+func ProxyFromEnvironment() string {
+	if value := os.Getenv("ALL_PROXY"); value != "" {
+		return value
+	}
+	return os.Getenv("all_proxy")
+}
+
+// httpConnectDialer tunnels TCP connections through a HTTP proxy, using the
+// CONNECT method.
+type httpConnectDialer struct {
+	address string
+	user    *url.Userinfo
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, d.address)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to the HTTP proxy: %s", err.Error())
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.user != nil {
+		password, _ := d.user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't send CONNECT request to the HTTP proxy: %s", err.Error())
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't read the HTTP proxy response: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("the HTTP proxy refused to connect to %s: %s", addr, resp.Status)
+	}
+
+	// The server may already have sent its banner along with the response
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn is a net.Conn whose first bytes were already read into reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// _listen accepts connections on a random local port until the end of the
+// test, serving each one with handle.
+func _listen(t *testing.T, handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// _pipe copies data both ways between a and b until either is closed.
+func _pipe(a net.Conn, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+// _httpProxy serves HTTP CONNECT requests, authenticated with the given
+// Proxy-Authorization header if not empty.
+func _httpProxy(t *testing.T, authorization string) string {
+	return _listen(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil || req.Method != http.MethodConnect {
+			conn.Close()
+			return
+		}
+		if authorization != "" && req.Header.Get("Proxy-Authorization") != authorization {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			conn.Close()
+			return
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+			conn.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		_pipe(&bufferedConn{Conn: conn, reader: reader}, target)
+	})
+}
+
+// _socks5Proxy serves SOCKS5 CONNECT requests with domain or IPv4 addresses,
+// authenticated with username and password if not empty.
+func _socks5Proxy(t *testing.T, username string, password string) string {
+	return _listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(reader, make([]byte, header[1])); err != nil {
+			return
+		}
+
+		if username == "" {
+			conn.Write([]byte{5, 0})
+		} else {
+			conn.Write([]byte{5, 2})
+			// RFC 1929: version, user length, user, password length, password
+			version, _ := reader.ReadByte()
+			userLength, _ := reader.ReadByte()
+			user := make([]byte, userLength)
+			io.ReadFull(reader, user)
+			passwordLength, _ := reader.ReadByte()
+			pass := make([]byte, passwordLength)
+			io.ReadFull(reader, pass)
+			if version != 1 || string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(reader, ip)
+			host = net.IP(ip).String()
+		case 3:
+			length, _ := reader.ReadByte()
+			domain := make([]byte, length)
+			io.ReadFull(reader, domain)
+			host = string(domain)
+		default:
+			return
+		}
+		port := make([]byte, 2)
+		io.ReadFull(reader, port)
+
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		_pipe(&bufferedConn{Conn: conn, reader: reader}, target)
+	})
+}
+
+func _dialThroughProxy(t *testing.T, proxyURL string) error {
+	target := _passwordServer(t, "password")
+	proxyDialer, err := NewProxyDialer(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := Dialer{Host: target, ClientConfig: _passwordConfig("password"), Proxy: proxyDialer}
+	client, err := dialer.Dial(context.Background())
+	if err == nil {
+		client.Close()
+	}
+	return err
+}
+
+func TestDialThroughHTTPProxy(t *testing.T) {
+	// base64("user:secret")
+	proxy := _httpProxy(t, "Basic dXNlcjpzZWNyZXQ=")
+
+	if err := _dialThroughProxy(t, "http://user:secret@"+proxy); err != nil {
+		t.Errorf("Couldn't connect through HTTP proxy: %s", err)
+	}
+	if err := _dialThroughProxy(t, "http://user:wrong@"+proxy); err == nil {
+		t.Errorf("Didn't fail with wrong proxy credentials")
+	}
+}
+
+func TestDialThroughSOCKS5Proxy(t *testing.T) {
+	if err := _dialThroughProxy(t, "socks5://"+_socks5Proxy(t, "", "")); err != nil {
+		t.Errorf("Couldn't connect through SOCKS5 proxy: %s", err)
+	}
+
+	proxy := _socks5Proxy(t, "user", "secret")
+	if err := _dialThroughProxy(t, "socks5://user:secret@"+proxy); err != nil {
+		t.Errorf("Couldn't connect through authenticated SOCKS5 proxy: %s", err)
+	}
+	if err := _dialThroughProxy(t, "socks5://user:wrong@"+proxy); err == nil {
+		t.Errorf("Didn't fail with wrong proxy credentials")
+	}
+}
+
+func TestProxyUnsupportedScheme(t *testing.T) {
+	if _, err := NewProxyDialer("ftp://localhost:21"); err == nil {
+		t.Errorf("Unsupported scheme was accepted")
+	}
+}
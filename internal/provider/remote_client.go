@@ -2,10 +2,17 @@ package provider
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -20,19 +27,8 @@ func (e Error) Error() string {
 	return fmt.Sprintf("`%s`\n  %s\n  %s", e.cmd, e.err, stderr)
 }
 
-func run(s *ssh.Session, cmd string) error {
-	var b bytes.Buffer
-	s.Stderr = &b
-	err := s.Run(cmd)
-
-	if err != nil {
-		return Error{
-			cmd:    cmd,
-			err:    err,
-			stderr: b.Bytes(),
-		}
-	}
-	return nil
+func (e Error) Unwrap() error {
+	return e.err
 }
 
 // SessionPool manages a pool of SSH sessions with a maximum concurrency limit
@@ -41,6 +37,8 @@ type SessionPool struct {
 	semaphore chan struct{} // Used as semaphore to limit concurrent sessions
 	mu        sync.Mutex
 	closed    bool
+	shells    []*remoteShell // Idle helper shells, each holding a slot
+	waiting   int            // Get calls waiting for a slot
 }
 
 // NewSessionPool creates a new session pool
@@ -56,8 +54,8 @@ func NewSessionPool(client *ssh.Client, maxSize int) *SessionPool {
 }
 
 // Get retrieves a session from the pool or creates a new one
-// This will block if maxSize sessions are already active
-func (p *SessionPool) Get() (*ssh.Session, error) {
+// This will block if maxSize sessions are already active, until ctx is done
+func (p *SessionPool) Get(ctx context.Context) (*ssh.Session, error) {
 	p.mu.Lock()
 	if p.closed {
 		p.mu.Unlock()
@@ -65,11 +63,39 @@ func (p *SessionPool) Get() (*ssh.Session, error) {
 	}
 	p.mu.Unlock()
 
-	// Acquire a slot (will block if pool is full)
-	p.semaphore <- struct{}{}
+	// Acquire a slot, taking the one of an idle shell if the pool is full
+	// (will block if every slot is in use)
+	select {
+	case p.semaphore <- struct{}{}:
+	default:
+		p.mu.Lock()
+		shell := p.popShell()
+		if shell == nil {
+			p.waiting++
+		}
+		p.mu.Unlock()
+		if shell != nil {
+			shell.session.Close()
+			break
+		}
+		select {
+		case p.semaphore <- struct{}{}:
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.waiting--
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+	}
 
 	// Create a new session
-	session, err := p.sshClient.NewSession()
+	p.mu.Lock()
+	client := p.sshClient
+	p.mu.Unlock()
+	session, err := client.NewSession()
 	if err != nil {
 		// Release the slot if session creation failed
 		<-p.semaphore
@@ -97,6 +123,51 @@ func (p *SessionPool) Put(session *ssh.Session) {
 	}
 }
 
+// GetShell returns an idle helper shell, or starts one in a new session
+func (p *SessionPool) GetShell(ctx context.Context) (*remoteShell, error) {
+	p.mu.Lock()
+	shell := p.popShell()
+	p.mu.Unlock()
+	if shell != nil {
+		return shell, nil
+	}
+
+	session, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shell, err = startShell(ctx, session)
+	if err != nil {
+		p.Put(session)
+		return nil, err
+	}
+	return shell, nil
+}
+
+// PutShell keeps the shell idle with its slot, for the next commands. Broken
+// shells are closed, and so are shells whose slot is awaited.
+func (p *SessionPool) PutShell(shell *remoteShell) {
+	p.mu.Lock()
+	if !shell.broken && !p.closed && p.waiting == 0 {
+		p.shells = append(p.shells, shell)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.Put(shell.session)
+}
+
+// popShell removes an idle shell from the pool, with p.mu held. It returns nil
+// if there is none.
+func (p *SessionPool) popShell() *remoteShell {
+	if len(p.shells) == 0 {
+		return nil
+	}
+	shell := p.shells[len(p.shells)-1]
+	p.shells = p.shells[:len(p.shells)-1]
+	return shell
+}
+
 // Close closes all sessions in the pool
 func (p *SessionPool) Close() {
 	p.mu.Lock()
@@ -105,308 +176,767 @@ func (p *SessionPool) Close() {
 		return
 	}
 	p.closed = true
+	shells := p.shells
+	p.shells = nil
 	p.mu.Unlock()
 
+	for _, shell := range shells {
+		shell.session.Close()
+	}
+
 	// No need to close the semaphore channel or drain it
 	// Any blocked Get() calls will be handled by the closed check
 }
 
 type RemoteClient struct {
-	sshClient   *ssh.Client
+	*remoteConnection
+
+	// become escalates the commands run with sudo, sudo itself if nil. Its
+	// presence is the default of resources.
+	become *Become
+
+	// env is the environment commands run in.
+	env CommandEnv
+}
+
+// connectionIdleTimeout is how long extra connections are kept without
+// sessions.
+const connectionIdleTimeout = time.Minute
+
+// remoteConnection is the SSH connections of a RemoteClient, shared with the
+// clients returned by RunAs and WithEnv. Sessions are spread across up to
+// maxConnections connections, opened when the others are full and closed once
+// idle, but the last one.
+type remoteConnection struct {
+	// dialer opens connections, and re-establishes them when they're lost.
+	// Nil disables reconnection.
+	dialer *Dialer
+
+	maxSessions    int
+	maxConnections int
+	idleTimeout    time.Duration
+
+	// commandTimeout bounds each command, none if zero.
+	commandTimeout time.Duration
+
+	// transport of file operations, one of the Transport constants. Empty
+	// means TransportShell.
+	transport string
+
+	mu       sync.Mutex
+	conns    []*sshConnection
+	dialing  int
+	sessions map[*ssh.Session]*sshConnection
+	closed   bool
+	noSFTP   bool // the server has no sftp subsystem, with TransportAuto
+
+	// scpProbed tells whether hasSCP is known, with TransportAuto.
+	scpProbed bool
+	hasSCP    bool
+
+	// remoteDialect is the dialect of the remote tools, once probed.
+	remoteDialect *dialect
+
+	// persistentShell runs commands in helper shells kept by the session
+	// pools, noShell is set if they can't start.
+	persistentShell bool
+	noShell         bool
+}
+
+// sshConnection is one of the connections of a remoteConnection. Its fields
+// but client, sessionPool and lost are guarded by remoteConnection.mu.
+type sshConnection struct {
+	client      *ssh.Client
 	sessionPool *SessionPool
-	sudo        bool
+	lost        chan struct{} // closed once client is dead
+
+	sessions  int
+	idleSince time.Time
 }
 
-// NewSession gets a session from the pool
-func (c *RemoteClient) NewSession() (*ssh.Session, error) {
-	return c.sessionPool.Get()
+// Sudo tells whether commands are escalated by default.
+func (c *RemoteClient) Sudo() bool {
+	return c.become != nil
 }
 
-// ReleaseSession returns a session to the pool
-func (c *RemoteClient) ReleaseSession(session *ssh.Session) {
-	c.sessionPool.Put(session)
+// escalation returns how commands run with sudo are escalated.
+func (c *RemoteClient) escalation() *Become {
+	if c.become == nil {
+		return NewBecome(true)
+	}
+	return c.become
+}
+
+// RunAs returns a client running commands as user, with the become method of
+// c, or sudo. It shares the connection of c.
+func (c *RemoteClient) RunAs(user string) *RemoteClient {
+	become := Become{Method: BecomeSudo}
+	if c.become != nil {
+		become = *c.become
+	}
+	become.User = user
+	return &RemoteClient{remoteConnection: c.remoteConnection, become: &become, env: c.env}
 }
 
-func (c *RemoteClient) WriteFile(content string, path string, sudo bool, ensureDir bool) error {
-	return c.WriteFileShell(content, path, sudo, ensureDir)
+// WithEnv returns a client running commands in the environment of c
+// overridden by env. It shares the connection of c.
+func (c *RemoteClient) WithEnv(env CommandEnv) *RemoteClient {
+	return &RemoteClient{remoteConnection: c.remoteConnection, become: c.become, env: c.env.Merge(env)}
 }
 
-func (c *RemoteClient) WriteFileShell(content string, path string, sudo bool, ensureDir bool) error {
-	session, err := c.NewSession()
+// NewSession gets a session on the least busy connection, reconnecting first
+// if the connection was lost.
+func (c *RemoteClient) NewSession(ctx context.Context) (*ssh.Session, error) {
+	session, conn, err := c.newSession(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer c.ReleaseSession(session)
+	c.mu.Lock()
+	c.sessions[session] = conn
+	c.mu.Unlock()
+	return session, nil
+}
 
-	stdin, err := session.StdinPipe()
+func (c *RemoteClient) newSession(ctx context.Context) (*ssh.Session, *sshConnection, error) {
+	conn, err := c.acquire(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	session, err := conn.sessionPool.Get(ctx)
+	if err != nil && ctx.Err() == nil && c.isLost(conn) {
+		c.release(conn)
+		if conn, err = c.acquire(ctx); err != nil {
+			return nil, nil, err
+		}
+		session, err = conn.sessionPool.Get(ctx)
 	}
+	if err != nil {
+		c.release(conn)
+		return nil, nil, err
+	}
+	return session, conn, nil
+}
 
-	go func() {
-		stdin.Write([]byte(content))
-		stdin.Close()
-	}()
+// ReleaseSession returns a session got from NewSession to the pool
+func (c *RemoteClient) ReleaseSession(session *ssh.Session) {
+	c.mu.Lock()
+	conn, ok := c.sessions[session]
+	delete(c.sessions, session)
+	c.mu.Unlock()
+	if ok {
+		c.releaseSession(conn, session)
+	}
+}
+
+func (c *RemoteClient) releaseSession(conn *sshConnection, session *ssh.Session) {
+	conn.sessionPool.Put(session)
+	c.release(conn)
+}
 
-	cmd := fmt.Sprintf("tee %s", path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+// acquire counts a session on the least busy connection, opening a new
+// connection if every one is full and there are less than maxConnections, or
+// none is left.
+func (c *RemoteClient) acquire(ctx context.Context) (*sshConnection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errors.New("client is closed")
 	}
-	cmd = fmt.Sprintf("cat /dev/stdin | %s", cmd)
-	if ensureDir {
-		dirPathElements := strings.Split(path, "/")
-		dirPathElements = dirPathElements[:len(dirPathElements)-1]
-		dirPath := strings.Join(dirPathElements, "/")
-		cmd = fmt.Sprintf("mkdir -p %s && %s", dirPath, cmd)
+
+	var best *sshConnection
+	live := make([]*sshConnection, 0, len(c.conns))
+	for _, conn := range c.conns {
+		if isClosed(conn.lost) {
+			continue
+		}
+		live = append(live, conn)
+		if best == nil || conn.sessions < best.sessions {
+			best = conn
+		}
+	}
+	c.conns = live
+
+	if best == nil || (best.sessions >= c.maxSessions && len(c.conns)+c.dialing < c.maxConnections) {
+		conn, err := c.dial(ctx, best == nil)
+		switch {
+		case err == nil:
+			best = conn
+		case best == nil:
+			return nil, err
+		}
+		// Otherwise wait for a session on the existing connections
 	}
-	return run(session, cmd)
+	best.sessions++
+	return best, nil
 }
 
-func (c *RemoteClient) ChmodFile(path string, permissions string, sudo bool) error {
-	session, err := c.NewSession()
+// dial opens a new connection, with c.mu held but while dialing. lost tells
+// whether the previous connections were lost, for the error message.
+func (c *RemoteClient) dial(ctx context.Context, lost bool) (*sshConnection, error) {
+	if c.dialer == nil {
+		return nil, errors.New("connection to the remote server lost")
+	}
+
+	c.dialing++
+	c.mu.Unlock()
+	client, err := c.dialer.Dial(ctx)
+	c.mu.Lock()
+	c.dialing--
+
 	if err != nil {
-		return err
+		if lost {
+			return nil, fmt.Errorf("connection to the remote server lost, couldn't reconnect: %s", err.Error())
+		}
+		return nil, fmt.Errorf("couldn't open another connection to the remote server: %s", err.Error())
+	}
+	if c.closed {
+		client.Close()
+		return nil, errors.New("client is closed")
+	}
+	conn := c.newConnection(client)
+	c.conns = append(c.conns, conn)
+	return conn, nil
+}
+
+// newConnection sets up the pool and the watch of client.
+func (c *RemoteClient) newConnection(client *ssh.Client) *sshConnection {
+	conn := &sshConnection{
+		client:      client,
+		sessionPool: NewSessionPool(client, c.maxSessions),
+		lost:        make(chan struct{}),
+	}
+	c.watch(conn)
+	return conn
+}
+
+// release uncounts a session of conn. Once idle, extra connections are closed
+// after idleTimeout.
+func (c *RemoteClient) release(conn *sshConnection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn.sessions--
+	if conn.sessions == 0 {
+		conn.idleSince = time.Now()
+		if len(c.conns) > 1 {
+			time.AfterFunc(c.idleTimeout, c.shrink)
+		}
+	}
+}
+
+// shrink closes the connections idle for idleTimeout, but the last one.
+func (c *RemoteClient) shrink() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
 	}
-	defer c.ReleaseSession(session)
 
-	cmd := fmt.Sprintf("chmod %s %s", permissions, path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+	conns := make([]*sshConnection, 0, len(c.conns))
+	for i, conn := range c.conns {
+		idle := conn.sessions == 0 && time.Since(conn.idleSince) >= c.idleTimeout
+		if idle && len(conns)+len(c.conns)-i > 1 {
+			conn.sessionPool.Close()
+			conn.client.Close()
+			continue
+		}
+		conns = append(conns, conn)
 	}
-	return run(session, cmd)
+	c.conns = conns
 }
 
-func (c *RemoteClient) CreateDir(path string, sudo bool) error {
-	session, err := c.NewSession()
+// drop forgets conn, found dead.
+func (c *RemoteClient) drop(conn *sshConnection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.conns {
+		if other == conn {
+			c.conns = append(c.conns[:i:i], c.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// exec runs fn in a new session. If the connection is lost while fn runs, fn
+// is run again over a new connection when retry is set, that is when running
+// the command twice is harmless. The command is stopped once ctx is done, or
+// after the command timeout.
+func (c *RemoteClient) exec(ctx context.Context, retry bool, fn func(session *ssh.Session) error) error {
+	if c.commandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.commandTimeout)
+		defer cancel()
+	}
+
+	session, conn, err := c.newSession(ctx)
 	if err != nil {
 		return err
 	}
-	defer c.ReleaseSession(session)
+	err = runSession(ctx, session, fn)
+	c.releaseSession(conn, session)
+	if err == nil || ctx.Err() != nil || !c.connectionLost(err, conn) {
+		return err
+	}
 
-	cmd := fmt.Sprintf("mkdir -p %s", path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+	if !retry {
+		return fmt.Errorf("connection lost, the command may or may not have completed: %s", err.Error())
 	}
-	return run(session, cmd)
+	// The lost connection is replaced
+	session, conn, err = c.newSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.releaseSession(conn, session)
+	return runSession(ctx, session, fn)
 }
 
-func (c *RemoteClient) ChgrpFile(path string, group string, sudo bool) error {
-	session, err := c.NewSession()
-	if err != nil {
+// runSession runs fn in session. If ctx is done first, the remote command is
+// signaled and the session closed, and the error of ctx is returned.
+func runSession(ctx context.Context, session *ssh.Session, fn func(session *ssh.Session) error) error {
+	finished := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// Not every server delivers signals, closing the channel ends the
+			// command anyway, with SIGHUP for commands in a terminal
+			session.Signal(ssh.SIGTERM)
+			session.Close()
+		case <-finished:
+		}
+	}()
+	err := fn(session)
+	close(finished)
+	<-stopped
+
+	if err == nil || ctx.Err() == nil {
 		return err
 	}
-	defer c.ReleaseSession(session)
+	var cmdErr Error
+	if errors.As(err, &cmdErr) {
+		cmdErr.err = ctx.Err()
+		return cmdErr
+	}
+	return ctx.Err()
+}
 
-	cmd := fmt.Sprintf("chgrp %s %s", group, path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+// interrupted returns the first of errs due to a timeout or a cancellation,
+// nil if there is none.
+func interrupted(errs ...error) error {
+	for _, err := range errs {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return err
+		}
 	}
+	return nil
+}
 
-	return run(session, cmd)
+// connectionLost tells whether err is due to the loss of conn, rather than to
+// the command itself.
+func (c *RemoteClient) connectionLost(err error, conn *sshConnection) bool {
+	var exitMissing *ssh.ExitMissingError
+	if !errors.As(err, &exitMissing) && !errors.Is(err, io.EOF) && !errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		return false
+	}
+	// The session may also have ended without exit status for other reasons
+	return c.isLost(conn)
 }
 
-func (c *RemoteClient) ChownFile(path string, owner string, sudo bool) error {
-	session, err := c.NewSession()
-	if err != nil {
-		return err
+// isLost tells whether conn is dead, checking that the transport still
+// answers if that isn't known yet. Dead connections are dropped.
+func (c *RemoteClient) isLost(conn *sshConnection) bool {
+	if !isClosed(conn.lost) {
+		if err := keepAlive(conn.client, keepAliveTimeout); err == nil {
+			return false
+		}
+		conn.client.Close()
 	}
-	defer c.ReleaseSession(session)
+	c.drop(conn)
+	return true
+}
 
-	cmd := fmt.Sprintf("chown %s %s", owner, path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+// watch closes conn.lost once its client is dead, and sends keepalives to
+// detect connections that silently went away.
+func (c *RemoteClient) watch(conn *sshConnection) {
+	client, lost := conn.client, conn.lost
+	go func() {
+		client.Wait()
+		close(lost)
+	}()
+
+	if c.dialer == nil || c.dialer.KeepAliveInterval <= 0 {
+		return
 	}
-	return run(session, cmd)
+	interval := c.dialer.KeepAliveInterval
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lost:
+				return
+			case <-ticker.C:
+			}
+			if err := keepAlive(client, keepAliveCountMax*interval); err != nil {
+				client.Close()
+				return
+			}
+		}
+	}()
 }
 
-func (c *RemoteClient) FileExists(path string, sudo bool) (bool, error) {
-	session, err := c.NewSession()
+// runCommand runs cmd in session, in the client environment and escalated if
+// sudo is set. stdin, if not nil, is fed to cmd. Failures are reported as
+// Error.
+func (c *RemoteClient) runCommand(session *ssh.Session, cmd string, sudo bool, stdin []byte, stdout io.Writer) error {
+	script := c.env.script(session, cmd, sudo)
+
+	var stderr bytes.Buffer
+	var err error
+	if sudo {
+		err = c.escalation().run(session, script, c.env.wrap, stdin, stdout, &stderr)
+	} else {
+		if stdin != nil {
+			session.Stdin = bytes.NewReader(stdin)
+		}
+		session.Stdout = stdout
+		session.Stderr = &stderr
+		err = session.Run(c.env.wrap(script))
+	}
+
 	if err != nil {
-		return false, err
+		return Error{
+			cmd:    cmd,
+			err:    err,
+			stderr: stderr.Bytes(),
+		}
 	}
-	defer c.ReleaseSession(session)
+	return nil
+}
 
-	cmd := fmt.Sprintf("test -f %s", path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+// output runs cmd, escalated if sudo is set, and returns its stdout. retry
+// tells whether cmd can be run again if the connection is lost.
+func (c *RemoteClient) output(ctx context.Context, retry bool, sudo bool, cmd string) ([]byte, error) {
+	var stdout bytes.Buffer
+	if done, err := c.runShell(ctx, retry, cmd, sudo, nil, &stdout); done {
+		return stdout.Bytes(), err
 	}
-	err = run(session, cmd)
+	err := c.exec(ctx, retry, func(session *ssh.Session) error {
+		stdout.Reset()
+		return c.runCommand(session, cmd, sudo, nil, &stdout)
+	})
+	return stdout.Bytes(), err
+}
 
-	if err != nil {
-		session2, err := c.NewSession()
+func (c *RemoteClient) WriteFile(ctx context.Context, content string, path string, sudo bool, ensureDir bool) error {
+	if done, err := c.trySFTP(ctx, sudo, true, "write "+path, func(client *sftp.Client) error {
+		return c.writeFileSFTP(client, content, path, ensureDir)
+	}); done {
+		return err
+	}
+	if scp, err := c.useSCP(ctx); err != nil || scp {
 		if err != nil {
-			return false, err
+			return err
 		}
-		defer c.ReleaseSession(session2)
+		return c.writeFileSCP(ctx, content, path, sudo, ensureDir)
+	}
+	return c.WriteFileShell(ctx, content, path, sudo, ensureDir)
+}
+
+func (c *RemoteClient) WriteFileShell(ctx context.Context, content string, path string, sudo bool, ensureDir bool) error {
+	cmd := fmt.Sprintf("cat /dev/stdin | tee %s", path)
+	if ensureDir {
+		dirPathElements := strings.Split(path, "/")
+		dirPathElements = dirPathElements[:len(dirPathElements)-1]
+		dirPath := strings.Join(dirPathElements, "/")
+		cmd = fmt.Sprintf("mkdir -p %s && %s", dirPath, cmd)
+	}
 
-		cmd := fmt.Sprintf("test ! -f %s", path)
-		if c.sudo {
-			cmd = fmt.Sprintf("sudo %s", cmd)
+	// The whole content is written again, so retrying is safe
+	if done, err := c.runShell(ctx, true, cmd, sudo, []byte(content), nil); done {
+		return err
+	}
+	return c.exec(ctx, true, func(session *ssh.Session) error {
+		return c.runCommand(session, cmd, sudo, []byte(content), nil)
+	})
+}
+
+func (c *RemoteClient) ChmodFile(ctx context.Context, path string, permissions string, sudo bool) error {
+	// Symbolic permissions are left to chmod
+	if perm, err := strconv.ParseUint(permissions, 8, 32); err == nil {
+		if done, err := c.trySFTP(ctx, sudo, true, "chmod "+path, func(client *sftp.Client) error {
+			return client.Chmod(c.sftpPath(path), fileMode(uint32(perm)))
+		}); done {
+			return err
 		}
-		return false, session2.Run(cmd)
 	}
+	_, err := c.output(ctx, true, sudo, fmt.Sprintf("chmod %s %s", permissions, path))
+	return err
+}
 
-	return true, nil
+func (c *RemoteClient) CreateDir(ctx context.Context, path string, sudo bool) error {
+	if done, err := c.trySFTP(ctx, sudo, true, "mkdir "+path, func(client *sftp.Client) error {
+		return c.createDirSFTP(client, path)
+	}); done {
+		return err
+	}
+	_, err := c.output(ctx, true, sudo, fmt.Sprintf("mkdir -p %s", path))
+	return err
 }
 
-func (c *RemoteClient) ReadFile(path string, sudo bool) (string, bool, error) {
-	return c.ReadFileShell(path, sudo)
+func (c *RemoteClient) ChgrpFile(ctx context.Context, path string, group string, sudo bool) error {
+	// Group names are resolved by chgrp
+	if gid, err := strconv.Atoi(group); err == nil && gid >= 0 {
+		if done, err := c.trySFTP(ctx, sudo, true, "chgrp "+path, func(client *sftp.Client) error {
+			return c.chownSFTP(client, path, -1, gid)
+		}); done {
+			return err
+		}
+	}
+	_, err := c.output(ctx, true, sudo, fmt.Sprintf("chgrp %s %s", group, path))
+	return err
 }
 
-func (c *RemoteClient) dirExists(path string) (bool, error) {
-	session, err := c.NewSession()
-	if err != nil {
+func (c *RemoteClient) ChownFile(ctx context.Context, path string, owner string, sudo bool) error {
+	// User names are resolved by chown
+	if uid, err := strconv.Atoi(owner); err == nil && uid >= 0 {
+		if done, err := c.trySFTP(ctx, sudo, true, "chown "+path, func(client *sftp.Client) error {
+			return c.chownSFTP(client, path, uid, -1)
+		}); done {
+			return err
+		}
+	}
+	_, err := c.output(ctx, true, sudo, fmt.Sprintf("chown %s %s", owner, path))
+	return err
+}
+
+func (c *RemoteClient) FileExists(ctx context.Context, path string, sudo bool) (bool, error) {
+	var exists bool
+	if done, err := c.trySFTP(ctx, sudo, true, "stat "+path, func(client *sftp.Client) error {
+		info, err := client.Stat(c.sftpPath(path))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		exists = err == nil && info.Mode().IsRegular()
+		return err
+	}); done {
+		return exists, err
+	}
+
+	_, err := c.output(ctx, true, sudo, fmt.Sprintf("test -f %s", path))
+	if interrupted(err) != nil {
 		return false, err
 	}
-	defer c.ReleaseSession(session)
 
-	cmd := fmt.Sprintf("[ -d \"%s\" ] && exit 0 || exit 1 ", path)
-	_, err = session.CombinedOutput(cmd)
 	if err != nil {
-		return false, nil
+		_, err := c.output(ctx, true, sudo, fmt.Sprintf("test ! -f %s", path))
+		return false, err
 	}
 
 	return true, nil
 }
 
-func (c *RemoteClient) ReadFileShell(path string, sudo bool) (string, bool, error) {
-	session, err := c.NewSession()
-	if err != nil {
-		return "", false, err
+func (c *RemoteClient) ReadFile(ctx context.Context, path string, sudo bool) (string, bool, error) {
+	var content string
+	var exists bool
+	if done, err := c.trySFTP(ctx, sudo, true, "read "+path, func(client *sftp.Client) (err error) {
+		content, exists, err = c.readFileSFTP(client, path)
+		return err
+	}); done {
+		return content, exists, err
+	}
+	if scp, err := c.useSCP(ctx); err != nil || scp {
+		if err != nil {
+			return "", false, err
+		}
+		return c.readFileSCP(ctx, path, sudo)
 	}
-	defer c.ReleaseSession(session)
+	return c.ReadFileShell(ctx, path, sudo)
+}
 
-	var stdout, stderr bytes.Buffer
-	session.Stdout = &stdout
-	session.Stderr = &stderr
+func (c *RemoteClient) dirExists(ctx context.Context, path string, sudo bool) (bool, error) {
+	var exists bool
+	if done, err := c.trySFTP(ctx, sudo, true, "stat "+path, func(client *sftp.Client) error {
+		info, err := client.Stat(c.sftpPath(path))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		exists = err == nil && info.IsDir()
+		return err
+	}); done {
+		return exists, err
+	}
 
-	cmd := fmt.Sprintf("cat %s", path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+	_, err := c.output(ctx, true, sudo, fmt.Sprintf("[ -d \"%s\" ] && exit 0 || exit 1 ", path))
+	if interrupted(err) != nil {
+		return false, err
 	}
-	err = session.Run(cmd)
 	if err != nil {
-		if bytes.Contains(stderr.Bytes(), []byte("No such file or directory")) {
-			return "", false, nil
-		}
-		return "", false, err
+		return false, nil
 	}
 
-	return stdout.String(), true, nil
+	return true, nil
 }
 
-func (c *RemoteClient) ReadFilePermissions(path string, sudo bool) (string, error) {
-	session, err := c.NewSession()
+func (c *RemoteClient) ReadFileShell(ctx context.Context, path string, sudo bool) (string, bool, error) {
+	output, err := c.output(ctx, true, sudo, fmt.Sprintf("cat %s", path))
 	if err != nil {
-		return "", err
+		var cmdErr Error
+		if errors.As(err, &cmdErr) && bytes.Contains(append(cmdErr.stderr, output...), []byte("No such file or directory")) {
+			return "", false, nil
+		}
+		return "", false, err
 	}
-	defer c.ReleaseSession(session)
 
-	cmd := fmt.Sprintf("stat -c %%a %s", path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
-	}
-	output, err := session.Output(cmd)
+	return string(output), true, nil
+}
+
+func (c *RemoteClient) ReadFilePermissions(ctx context.Context, path string, sudo bool) (string, error) {
+	permissions, err := c.StatFile(ctx, path, "a", sudo)
 	if err != nil {
 		return "", err
 	}
-
-	permissions := strings.ReplaceAll(string(output), "\n", "")
-	if len(permissions) > 0 && len(permissions) < 4 {
-		permissions = fmt.Sprintf("0%s", permissions)
-	}
-	return permissions, nil
+	return padPermissions(permissions), nil
 }
 
-func (c *RemoteClient) ReadFileOwner(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "u", sudo)
+func (c *RemoteClient) ReadFileOwner(ctx context.Context, path string, sudo bool) (string, error) {
+	return c.StatFile(ctx, path, "u", sudo)
 }
 
-func (c *RemoteClient) ReadFileGroup(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "g", sudo)
+func (c *RemoteClient) ReadFileGroup(ctx context.Context, path string, sudo bool) (string, error) {
+	return c.StatFile(ctx, path, "g", sudo)
 }
 
-func (c *RemoteClient) ReadFileOwnerName(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "U", sudo)
+func (c *RemoteClient) ReadFileOwnerName(ctx context.Context, path string, sudo bool) (string, error) {
+	return c.StatFile(ctx, path, "U", sudo)
 }
 
-func (c *RemoteClient) ReadFileGroupName(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "G", sudo)
+func (c *RemoteClient) ReadFileGroupName(ctx context.Context, path string, sudo bool) (string, error) {
+	return c.StatFile(ctx, path, "G", sudo)
 }
 
-func (c *RemoteClient) StatFile(path string, char string, sudo bool) (string, error) {
-	session, err := c.NewSession()
+func (c *RemoteClient) StatFile(ctx context.Context, path string, char string, sudo bool) (string, error) {
+	if strings.Contains(sftpStatChars, char) {
+		var value string
+		if done, err := c.trySFTP(ctx, sudo, true, "stat "+path, func(client *sftp.Client) error {
+			stat, err := statSFTP(client, c.sftpPath(path))
+			if err != nil {
+				return err
+			}
+			value = statValue(stat, char)
+			return nil
+		}); done {
+			return value, err
+		}
+	}
+
+	dialect, err := c.dialect(ctx)
 	if err != nil {
 		return "", err
 	}
-	defer c.ReleaseSession(session)
-
-	cmd := fmt.Sprintf("stat -c %%%s %s", char, path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+	cmd, err := dialect.statCommand(path, char)
+	if err != nil {
+		return "", err
 	}
-	output, err := session.Output(cmd)
+	output, err := c.output(ctx, true, sudo, cmd)
 	if err != nil {
 		return "", err
 	}
-
-	group := strings.ReplaceAll(string(output), "\n", "")
-	return group, nil
+	return dialect.parseStat(output, char)
 }
 
-func (c *RemoteClient) DeleteFolder(path string, sudo bool) error {
-	session, err := c.NewSession()
-	if err != nil {
+func (c *RemoteClient) DeleteFolder(ctx context.Context, path string, sudo bool) error {
+	if done, err := c.trySFTP(ctx, sudo, true, "remove "+path, func(client *sftp.Client) error {
+		err := client.RemoveAll(c.sftpPath(path))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}); done {
 		return err
 	}
-	defer c.ReleaseSession(session)
+	_, err := c.output(ctx, true, sudo, fmt.Sprintf("rm -rf %s", path))
+	return err
+}
 
-	cmd := fmt.Sprintf("rm -rf %s", path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+func (c *RemoteClient) DeleteFile(ctx context.Context, path string, sudo bool) error {
+	// A second removal would fail if the first one went through
+	if done, err := c.trySFTP(ctx, sudo, false, "remove "+path, func(client *sftp.Client) error {
+		return client.Remove(c.sftpPath(path))
+	}); done {
+		return err
 	}
-	return run(session, cmd)
+	return c.DeleteFileShell(ctx, path, sudo)
 }
 
-func (c *RemoteClient) DeleteFile(path string, sudo bool) error {
-	return c.DeleteFileShell(path, sudo)
+func (c *RemoteClient) DeleteFileShell(ctx context.Context, path string, sudo bool) error {
+	// A second `rm` would fail if the first one went through
+	_, err := c.output(ctx, false, sudo, fmt.Sprintf("rm %s", path))
+	return err
 }
 
-func (c *RemoteClient) DeleteFileShell(path string, sudo bool) error {
-	session, err := c.NewSession()
-	if err != nil {
+// RenameFile moves from to to, replacing to if it exists.
+func (c *RemoteClient) RenameFile(ctx context.Context, from string, to string, sudo bool) error {
+	// Once moved, from no longer exists
+	if done, err := c.trySFTP(ctx, sudo, false, "rename "+from, func(client *sftp.Client) error {
+		return c.renameSFTP(client, from, to)
+	}); done {
 		return err
 	}
-	defer c.ReleaseSession(session)
-
-	cmd := fmt.Sprintf("rm %s", path)
-	if c.sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
-	}
-	return run(session, cmd)
+	_, err := c.output(ctx, false, sudo, fmt.Sprintf("mv %s %s", from, to))
+	return err
 }
 
 func NewRemoteClient(host string, clientConfig *ssh.ClientConfig, sudo bool, maxSessions int) (*RemoteClient, error) {
-	client, err := ssh.Dial("tcp", host, clientConfig)
+	return NewRemoteClientWithDialer(context.Background(), &Dialer{Host: host, ClientConfig: clientConfig}, NewBecome(sudo), maxSessions)
+}
+
+// NewRemoteClientWithDialer connects to the remote host with dialer, to go
+// through jump hosts or a proxy, or retry. Commands are escalated with become,
+// if not nil.
+func NewRemoteClientWithDialer(ctx context.Context, dialer *Dialer, become *Become, maxSessions int) (*RemoteClient, error) {
+	client, err := dialer.Dial(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't establish a connection to the remote server: %s", err.Error())
 	}
 
-	// Create session pool with max size of 8 (leave some buffer below SSHD's default of 10)
-	sessionPool := NewSessionPool(client, maxSessions)
-
-	return &RemoteClient{
-		sshClient:   client,
-		sessionPool: sessionPool,
-		sudo:        sudo,
-	}, nil
+	if maxSessions <= 0 {
+		maxSessions = 10 // Default to SSHD's default MaxSessions
+	}
+	c := &RemoteClient{
+		remoteConnection: &remoteConnection{
+			dialer:         dialer,
+			maxSessions:    maxSessions,
+			maxConnections: 1,
+			idleTimeout:    connectionIdleTimeout,
+			sessions:       map[*ssh.Session]*sshConnection{},
+		},
+		become: become,
+	}
+	c.conns = []*sshConnection{c.newConnection(client)}
+	return c, nil
 }
 
 func (c *RemoteClient) Close() error {
-	c.sessionPool.Close()
-	return c.sshClient.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var err error
+	for _, conn := range c.conns {
+		conn.sessionPool.Close()
+		if closeErr := conn.client.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
+// GetSSHClient returns the first connection, nil if there is none
 func (c *RemoteClient) GetSSHClient() *ssh.Client {
-	return c.sshClient
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.conns) == 0 {
+		return nil
+	}
+	return c.conns[0].client
 }
@@ -2,10 +2,16 @@ package provider
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -35,7 +41,7 @@ func TestSshRootPasswordAuth(t *testing.T) {
 }
 
 func TestWriteFile(t *testing.T) {
-	err := _client("root").WriteFile("blabetiblou", "/tmp/test", true, false)
+	err := _client("root").WriteFile(context.Background(), "blabetiblou", "/tmp/test", true, false)
 
 	if err != nil {
 		t.Errorf("unable to create remote file: %s", err)
@@ -43,7 +49,7 @@ func TestWriteFile(t *testing.T) {
 }
 
 func TestWriteFileEnsureDir(t *testing.T) {
-	err := _client("root").WriteFile("blabetiblou", "/tmp/blabetiblou/test", true, true)
+	err := _client("root").WriteFile(context.Background(), "blabetiblou", "/tmp/blabetiblou/test", true, true)
 
 	if err != nil {
 		t.Errorf("unable to create remote file: %s", err)
@@ -54,6 +60,7 @@ func TestWriteFileEnsureDirFail(t *testing.T) {
 	// "randomize" the path to make sure it doesn't exist yet
 	path := fmt.Sprintf("/etc/doesnt-exists-%d/file", time.Now().UnixMilli())
 	err := _client("root").WriteFile(
+		context.Background(),
 		"blabetiblou",
 		path,
 		true, false,
@@ -73,7 +80,7 @@ func TestWriteFileEnsureDirFail(t *testing.T) {
 }
 
 func TestWriteFileNonSudoFail(t *testing.T) {
-	err := _client("raphaeljoie").WriteFile("blabetiblou", "/home/file", false, false)
+	err := _client("raphaeljoie").WriteFile(context.Background(), "blabetiblou", "/home/file", false, false)
 
 	if err == nil {
 		t.Errorf("Didn't fail as expected")
@@ -87,3 +94,202 @@ func TestWriteFileNonSudoFail(t *testing.T) {
 		t.Errorf("Didn't fail as expected %s", localError.stderr)
 	}
 }
+
+func _remoteClient(t *testing.T, host string) *RemoteClient {
+	client, err := NewRemoteClientWithDialer(context.Background(), &Dialer{Host: host, ClientConfig: _passwordConfig("secret")}, nil, 5)
+	if err != nil {
+		t.Fatalf("Couldn't connect: %s", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRemoteClientReconnects(t *testing.T) {
+	host, drop := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		fmt.Fprint(stdout, "content")
+		return 0
+	})
+	client := _remoteClient(t, host)
+
+	for i := 0; i < 2; i++ {
+		content, exists, err := client.ReadFile(context.Background(), "/tmp/file", false)
+		if err != nil || !exists || content != "content" {
+			t.Fatalf("Read #%d failed: %q, %t, %v", i+1, content, exists, err)
+		}
+		drop()
+	}
+}
+
+func TestRemoteClientRetriesSafeCommands(t *testing.T) {
+	var mu sync.Mutex
+	var drop func()
+	calls := map[string]int{}
+	host, drop := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		mu.Lock()
+		calls[cmd]++
+		first := calls[cmd] == 1
+		mu.Unlock()
+
+		if first {
+			// The connection is lost while the command runs
+			drop()
+			return 0
+		}
+		fmt.Fprint(stdout, "content")
+		return 0
+	})
+	client := _remoteClient(t, host)
+
+	content, _, err := client.ReadFile(context.Background(), "/tmp/file", false)
+	if err != nil || content != "content" {
+		t.Errorf("Read wasn't retried: %q, %v", content, err)
+	}
+
+	err = client.DeleteFile(context.Background(), "/tmp/file", false)
+	if err == nil || !strings.Contains(err.Error(), "connection lost") {
+		t.Errorf("Expected the deletion to fail with a lost connection: %v", err)
+	}
+	mu.Lock()
+	if calls["rm /tmp/file"] != 1 {
+		t.Errorf("Deletion was retried: %d calls", calls["rm /tmp/file"])
+	}
+	mu.Unlock()
+
+	// The next command goes through a new connection
+	if err := client.ChmodFile(context.Background(), "/tmp/file", "0644", false); err != nil {
+		t.Errorf("Chmod failed after reconnection: %v", err)
+	}
+}
+
+func TestRemoteClientClosed(t *testing.T) {
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		return 0
+	})
+	client := _remoteClient(t, host)
+	client.Close()
+
+	if _, _, err := client.ReadFile(context.Background(), "/tmp/file", false); err == nil {
+		t.Errorf("Closed client reconnected")
+	}
+}
+
+// _hangingServer starts a SSH server whose commands never complete, closing
+// stopped once the client gives up on one.
+func _hangingServer(t *testing.T) (string, chan struct{}) {
+	stopped := make(chan struct{})
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		for {
+			if _, err := stdout.Write([]byte(".")); err != nil {
+				close(stopped)
+				return 1
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	return host, stopped
+}
+
+func _waitStopped(t *testing.T, stopped chan struct{}) {
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("The remote command wasn't stopped")
+	}
+}
+
+func TestRemoteClientCommandTimeout(t *testing.T) {
+	host, stopped := _hangingServer(t)
+	client := _remoteClient(t, host)
+	client.commandTimeout = 100 * time.Millisecond
+
+	_, _, err := client.ReadFile(context.Background(), "/tmp/file", false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout: %v", err)
+	}
+	_waitStopped(t, stopped)
+
+	var diags diag.Diagnostics
+	addCommandError(&diags, "Error Reading remote file", "", err)
+	if diags.ErrorsCount() != 1 || diags[0].Summary() != "Remote command timed out" {
+		t.Errorf("Unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestRemoteClientCancel(t *testing.T) {
+	host, stopped := _hangingServer(t)
+	client := _remoteClient(t, host)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	err := client.DeleteFile(ctx, "/tmp/file", false)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation: %v", err)
+	}
+	_waitStopped(t, stopped)
+
+	var diags diag.Diagnostics
+	addCommandError(&diags, "Error deleting file", "", err)
+	if diags.ErrorsCount() != 1 || diags[0].Summary() != "Remote command cancelled" {
+		t.Errorf("Unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestRemoteClientSpreadsSessions(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		started <- struct{}{}
+		<-release
+		return 0
+	})
+	client, err := NewRemoteClientWithDialer(context.Background(), &Dialer{Host: host, ClientConfig: _passwordConfig("secret")}, nil, 1)
+	if err != nil {
+		t.Fatalf("Couldn't connect: %s", err)
+	}
+	defer client.Close()
+	client.maxConnections = 3
+	client.idleTimeout = 50 * time.Millisecond
+
+	connections := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.conns)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := client.ReadFile(context.Background(), "/tmp/file", false); err != nil {
+				t.Errorf("Read failed: %s", err)
+			}
+		}()
+	}
+	// With one session per connection, the commands only run concurrently
+	// over 3 connections
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only %d commands running, over %d connections", i, connections())
+		}
+	}
+	if n := connections(); n != 3 {
+		t.Errorf("Expected 3 connections, got %d", n)
+	}
+	close(release)
+	wg.Wait()
+
+	// Idle connections are closed, but the last one
+	deadline := time.Now().Add(5 * time.Second)
+	for connections() > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := connections(); n != 1 {
+		t.Errorf("Expected the idle connections to be closed, %d left", n)
+	}
+	if _, _, err := client.ReadFile(context.Background(), "/tmp/file", false); err != nil {
+		t.Errorf("Read failed after shrinking: %s", err)
+	}
+}
@@ -0,0 +1,389 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// remoteClients hands out the RemoteClient of the provider host, or of the host
+// of a resource conn block. Conn blocks with the same parameters
+// share one client, hence one SSH connection and session pool.
+type remoteClients struct {
+	provider *hashicupsProvider
+	config   hashicupsProviderModel
+
+	// configKnown is false while the provider config depends on values unknown
+	// until apply.
+	configKnown bool
+
+	// setupDone is set once setup completed, or failed for another reason
+	// than the context of the resource running it.
+	setupMu    sync.Mutex
+	setupDone  bool
+	setupDiags diag.Diagnostics
+
+	// Settings shared by every connection, resolved by setup. dialer has no
+	// Host and ClientConfig.
+	dialer            Dialer
+	jumpHostVerifiers []*hostKeyVerifier
+	agents            sshAgents
+	algorithms        SSHAlgorithms
+	become            *Become
+	maxSessions       int
+	maxConnections    int
+	transport         string
+	persistentShell   bool
+	commandTimeout    time.Duration
+	env               CommandEnv
+
+	// defaultClient is the client of the provider host.
+	defaultClient cachedClient
+
+	mu      sync.Mutex
+	clients map[string]*cachedClient
+}
+
+// cachedClient is a client of remoteClients, connected once by the first
+// resource needing it.
+type cachedClient struct {
+	mu     sync.Mutex
+	client *RemoteClient
+}
+
+// Get returns the client for connection, or the provider one if connection is
+// nil, connecting on first use. attributes is the path of the connection
+// block, for diagnostics.
+func (c *remoteClients) Get(ctx context.Context, connection *hostModel, attributes path.Path, diags *diag.Diagnostics) *RemoteClient {
+	// Conn blocks depend on the provider settings too, e.g. its jump hosts
+	if !c.configKnown {
+		diags.AddError(
+			"Unknown provider configuration",
+			"The provider configuration depends on values unknown until apply, it can't connect yet.",
+		)
+		return nil
+	}
+
+	if !c.runSetup(ctx, diags) {
+		return nil
+	}
+
+	if connection == nil {
+		if c.config.Host.IsNull() {
+			diags.AddError(
+				"Missing connection",
+				"The provider has no `host`, so the resource must have a `conn` block.",
+			)
+			return nil
+		}
+		return c.defaultClient.get(func() *RemoteClient {
+			return c.connect(ctx, c.config.Host.ValueString(), c.config.credentials(), c.config.HostKeyFingerprints, path.Empty(), diags)
+		})
+	}
+
+	key := connection.cacheKey()
+	c.mu.Lock()
+	cached, ok := c.clients[key]
+	if !ok {
+		cached = &cachedClient{}
+		c.clients[key] = cached
+	}
+	c.mu.Unlock()
+
+	return cached.get(func() *RemoteClient {
+		return c.connect(ctx, connection.Host.ValueString(), connection.credentials(), connection.HostKeyFingerprints, attributes, diags)
+	})
+}
+
+// Unknown tells whether the clients depend on provider values unknown until
+// apply, so that they can't be connected yet. Conn blocks depend on them too,
+// as they share the provider settings.
+func (c *remoteClients) Unknown() bool {
+	return !c.configKnown
+}
+
+// get returns the client, connecting it if that wasn't done yet. Failures
+// aren't kept, the next caller tries again.
+func (c *cachedClient) get(connect func() *RemoteClient) *RemoteClient {
+	// Hold the entry only, to connect to different hosts concurrently
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		c.client = connect()
+	}
+	return c.client
+}
+
+// runSetup runs setup once for all the resources, and tells whether it
+// succeeded.
+// A setup interrupted by the context of a resource, e.g. on its timeout, is
+// run again by the next one.
+func (c *remoteClients) runSetup(ctx context.Context, diags *diag.Diagnostics) bool {
+	c.setupMu.Lock()
+	defer c.setupMu.Unlock()
+	if !c.setupDone {
+		// setup resolves the config in place
+		config := c.config
+		var setupDiags diag.Diagnostics
+		c.setup(ctx, &setupDiags)
+		if setupDiags.HasError() && ctx.Err() != nil {
+			c.config = config
+			diags.Append(setupDiags...)
+			return false
+		}
+		c.setupDone, c.setupDiags = true, setupDiags
+	}
+	diags.Append(c.setupDiags...)
+	return !c.setupDiags.HasError()
+}
+
+// setup resolves the provider settings shared by every connection.
+func (c *remoteClients) setup(ctx context.Context, diags *diag.Diagnostics) {
+	p, config := c.provider, &c.config
+	var jumpHostModels []hostModel
+	if !config.JumpHosts.IsNull() {
+		diags.Append(config.JumpHosts.ElementsAs(ctx, &jumpHostModels, false)...)
+		if diags.HasError() {
+			return
+		}
+	}
+	if !config.SSHConfigFile.IsNull() && !config.Host.IsNull() {
+		p.applySSHConfig(config, &jumpHostModels, diags)
+		if diags.HasError() {
+			return
+		}
+	}
+
+	// Run once per provider instance, the credentials are kept in config
+	if !config.CredentialCommand.IsNull() {
+		p.applyCredentialCommand(ctx, config, diags)
+		if diags.HasError() {
+			return
+		}
+	}
+
+	// The agent of the provider is connected once, for every host using it
+	if config.Agent.ValueBool() {
+		if _, err := c.agents.Get(expandPath(config.AgentSocket.ValueString())); err != nil {
+			diags.AddAttributeError(
+				path.Root("agent"),
+				"SSH agent error",
+				err.Error(),
+			)
+			return
+		}
+	}
+
+	for _, algorithms := range []struct {
+		attribute string
+		list      types.List
+		values    *[]string
+	}{
+		{"ciphers", config.Ciphers, &c.algorithms.Ciphers},
+		{"key_exchanges", config.KeyExchanges, &c.algorithms.KeyExchanges},
+		{"macs", config.MACs, &c.algorithms.MACs},
+		{"host_key_algorithms", config.HostKeyAlgorithms, &c.algorithms.HostKeyAlgorithms},
+	} {
+		if algorithms.list.IsNull() {
+			continue
+		}
+		diags.Append(algorithms.list.ElementsAs(ctx, algorithms.values, false)...)
+		for i, name := range *algorithms.values {
+			if SupportedAlgorithm(algorithms.attribute, name) {
+				continue
+			}
+			diags.AddAttributeError(
+				path.Root(algorithms.attribute).AtListIndex(i),
+				"Unsupported SSH algorithm",
+				fmt.Sprintf("%q isn't supported, expected one of %s.", name, strings.Join(supportedAlgorithms[algorithms.attribute], ", ")),
+			)
+		}
+	}
+	if diags.HasError() {
+		return
+	}
+
+	jumpHosts := make([]JumpHost, len(jumpHostModels))
+	c.jumpHostVerifiers = make([]*hostKeyVerifier, len(jumpHostModels))
+	for i, jumpHost := range jumpHostModels {
+		attributes := path.Root("jump_host").AtListIndex(i)
+		c.jumpHostVerifiers[i] = p.hostKeyVerifier(ctx, *config, jumpHost.HostKeyFingerprints, attributes, diags)
+		jumpHostConfig, _ := p.sshClientConfig(ctx, jumpHost.credentials(), &c.agents, attributes, diags)
+		if diags.HasError() {
+			return
+		}
+		jumpHostConfig.HostKeyCallback = c.jumpHostVerifiers[i].Callback
+		c.algorithms.Apply(jumpHostConfig)
+		jumpHosts[i] = JumpHost{
+			Host:         jumpHost.Host.ValueString(),
+			ClientConfig: jumpHostConfig,
+		}
+	}
+
+	c.become = NewBecome(config.Sudo.ValueBool())
+	if config.Become != nil {
+		c.become = &Become{
+			Method:     config.Become.Method.ValueString(),
+			User:       config.Become.User.ValueString(),
+			Password:   config.Become.Password.ValueString(),
+			RequireTTY: config.Become.RequireTTY.ValueBool(),
+		}
+		switch c.become.Method {
+		case "":
+			c.become.Method = BecomeSudo
+		case BecomeSudo, BecomeDoas, BecomeSu:
+		default:
+			diags.AddAttributeError(
+				path.Root("become").AtName("method"),
+				"Invalid become method",
+				fmt.Sprintf("Expected one of %q, %q or %q, got %q.", BecomeSudo, BecomeDoas, BecomeSu, c.become.Method),
+			)
+			return
+		}
+	}
+	c.env = commandEnv(ctx, config.Shell, config.Environment, config.Umask, config.WorkingDir, path.Empty(), diags)
+	if diags.HasError() {
+		return
+	}
+
+	c.maxSessions = 5 // Default value
+	if !config.MaxSessions.IsNull() {
+		c.maxSessions = int(config.MaxSessions.ValueInt64())
+	}
+	c.maxConnections = 1
+	if !config.MaxConnections.IsNull() {
+		c.maxConnections = int(config.MaxConnections.ValueInt64())
+		if c.maxConnections < 1 {
+			diags.AddAttributeError(
+				path.Root("max_connections"),
+				"Invalid max_connections",
+				fmt.Sprintf("Expected at least 1 connection, got %d.", c.maxConnections),
+			)
+			return
+		}
+	}
+
+	c.transport = TransportShell
+	if !config.Transport.IsNull() {
+		c.transport = config.Transport.ValueString()
+		switch c.transport {
+		case TransportShell, TransportSFTP, TransportSCP, TransportAuto:
+		default:
+			diags.AddAttributeError(
+				path.Root("transport"),
+				"Invalid transport",
+				fmt.Sprintf("Expected one of %q, %q, %q or %q, got %q.", TransportShell, TransportSFTP, TransportSCP, TransportAuto, c.transport),
+			)
+			return
+		}
+	}
+
+	c.persistentShell = config.PersistentShell.ValueBool()
+
+	c.dialer = Dialer{JumpHosts: jumpHosts}
+	c.dialer.ConnectTimeout = parseDuration(config.ConnectTimeout, path.Root("connect_timeout"), diags)
+	c.dialer.ConnectRetryTimeout = parseDuration(config.ConnectRetryTimeout, path.Root("connect_retry_timeout"), diags)
+	c.dialer.KeepAliveInterval = 30 * time.Second
+	if !config.KeepAliveInterval.IsNull() {
+		c.dialer.KeepAliveInterval = parseDuration(config.KeepAliveInterval, path.Root("keepalive_interval"), diags)
+	}
+	c.commandTimeout = parseDuration(config.CommandTimeout, path.Root("command_timeout"), diags)
+	if diags.HasError() {
+		return
+	}
+
+	proxyURL := config.Proxy.ValueString()
+	if config.Proxy.IsNull() {
+		proxyURL = ProxyFromEnvironment()
+	}
+	if proxyURL != "" {
+		var err error
+		c.dialer.Proxy, err = NewProxyDialer(proxyURL)
+		if err != nil {
+			diags.AddAttributeError(
+				path.Root("proxy"),
+				"Proxy configuration error",
+				err.Error(),
+			)
+		}
+	}
+}
+
+// connect opens a client to host, through the provider jump hosts and proxy.
+func (c *remoteClients) connect(ctx context.Context, host string, creds sshCredentials, hostKeyFingerprints types.List, attributes path.Path, diags *diag.Diagnostics) *RemoteClient {
+	verifier := c.provider.hostKeyVerifier(ctx, c.config, hostKeyFingerprints, attributes, diags)
+	if diags.HasError() {
+		return nil
+	}
+
+	clientConfig, certificate := c.provider.sshClientConfig(ctx, creds, &c.agents, attributes, diags)
+	if diags.HasError() {
+		return nil
+	}
+	clientConfig.HostKeyCallback = verifier.Callback
+	c.algorithms.Apply(clientConfig)
+
+	dialer := c.dialer
+	dialer.Host = host
+	dialer.ClientConfig = clientConfig
+
+	client, err := NewRemoteClientWithDialer(ctx, &dialer, c.become, c.maxSessions)
+	if err != nil {
+		for _, hopVerifier := range append(append([]*hostKeyVerifier{}, c.jumpHostVerifiers...), verifier) {
+			if hostKeyErr := hopVerifier.LastError(); hostKeyErr != nil {
+				diags.AddError("Host key verification failed", hostKeyErr.Error())
+				return nil
+			}
+		}
+		if negotiation := ParseNegotiationError(err); negotiation != nil {
+			detail := fmt.Sprintf("No %s algorithm is supported by both sides.\n\nServer offered: %s\nProvider offered: %s",
+				negotiation.What, strings.Join(negotiation.ServerOffered, ", "), strings.Join(negotiation.ClientOffered, ", "))
+			if attribute := negotiation.Attribute(); attribute != "" {
+				detail += fmt.Sprintf("\n\nSet `%s` to include one of the algorithms offered by the server.", attribute)
+			}
+			diags.AddError("SSH algorithm negotiation failed", detail+"\n\n"+err.Error())
+			return nil
+		}
+		if certificate != nil && IsAuthError(err) {
+			diags.AddError(
+				"Certificate authentication rejected",
+				fmt.Sprintf("The remote server rejected the %s.\n\n%s", DescribeCertificate(certificate, time.Now()), err.Error()),
+			)
+			return nil
+		}
+		diags.AddError(
+			"Unable to Create Remote API Client",
+			"An unexpected error occurred when creating the HashiCups API client. "+
+				"If the error is not clear, please contact the provider developers.\n\n"+
+				"HashiCups Client Error: "+err.Error(),
+		)
+		return nil
+	}
+	client.commandTimeout = c.commandTimeout
+	client.maxConnections = c.maxConnections
+	client.transport = c.transport
+	client.persistentShell = c.persistentShell
+	client.env = c.env
+	return client
+}
+
+// cacheKey identifies the connection parameters, without keeping secrets in
+// clear.
+func (m hostModel) cacheKey() string {
+	hash := sha256.New()
+	for _, value := range []fmt.Stringer{
+		m.Host, m.Username, m.Password, m.PrivateKey, m.PrivateKeyPath, m.Passphrase,
+		m.CertificatePath, m.Agent, m.AgentSocket, m.HostKeyFingerprints,
+	} {
+		fmt.Fprintf(hash, "%q\n", value.String())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
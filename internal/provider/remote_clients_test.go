@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func _remoteClients() *remoteClients {
+	return &remoteClients{
+		provider:    &hashicupsProvider{},
+		configKnown: true,
+		clients:     map[string]*cachedClient{},
+	}
+}
+
+func _connection(host string, password string) *hostModel {
+	return &hostModel{
+		Host:     types.StringValue(host),
+		Username: types.StringValue("root"),
+		Password: types.StringValue(password),
+	}
+}
+
+func TestRemoteClientsShareConnections(t *testing.T) {
+	handler := func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 { return 0 }
+	first, _ := _execServer(t, handler)
+	second, _ := _execServer(t, handler)
+	clients := _remoteClients()
+
+	var diags diag.Diagnostics
+	a := clients.Get(context.Background(), _connection(first, "secret"), path.Root("conn"), &diags)
+	b := clients.Get(context.Background(), _connection(first, "secret"), path.Root("conn"), &diags)
+	c := clients.Get(context.Background(), _connection(second, "secret"), path.Root("conn"), &diags)
+	if diags.HasError() {
+		t.Fatalf("Couldn't connect: %v", diags)
+	}
+	t.Cleanup(func() { a.Close(); c.Close() })
+
+	if a != b {
+		t.Errorf("Same connection parameters didn't share the client")
+	}
+	if a == c {
+		t.Errorf("Different hosts shared the client")
+	}
+}
+
+func TestRemoteClientsConnectionFailure(t *testing.T) {
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 { return 0 })
+	clients := _remoteClients()
+
+	var diags diag.Diagnostics
+	client := clients.Get(context.Background(), _connection(host, "wrong"), path.Root("conn"), &diags)
+	if client != nil || !diags.HasError() || diags[0].Summary() != "Unable to Create Remote API Client" {
+		t.Errorf("Expected a connection error: %v", diags)
+	}
+}
+
+func TestRemoteClientsMissingConnection(t *testing.T) {
+	var diags diag.Diagnostics
+	_remoteClients().Get(context.Background(), nil, path.Root("conn"), &diags)
+	if !diags.HasError() {
+		t.Errorf("Expected an error without provider host nor conn block")
+	}
+}
+
+func TestRemoteClientsConnectOnFirstUse(t *testing.T) {
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 { return 0 })
+	clients := _remoteClients()
+	clients.config.Host = types.StringValue(host)
+	clients.config.Username = types.StringValue("root")
+	clients.config.Password = types.StringValue("secret")
+
+	if clients.defaultClient.client != nil {
+		t.Fatalf("Connected before first use")
+	}
+	var diags diag.Diagnostics
+	client := clients.Get(context.Background(), nil, path.Root("conn"), &diags)
+	if diags.HasError() {
+		t.Fatalf("Couldn't connect: %v", diags)
+	}
+	defer client.Close()
+	if clients.Get(context.Background(), nil, path.Root("conn"), &diags) != client {
+		t.Errorf("Provider client wasn't reused")
+	}
+}
+
+func TestRemoteClientsUnknownConfig(t *testing.T) {
+	clients := _remoteClients()
+	clients.configKnown = false
+	clients.config.Host = types.StringUnknown()
+
+	if !clients.Unknown() {
+		t.Errorf("Clients aren't reported unknown")
+	}
+
+	var diags diag.Diagnostics
+	if clients.Get(context.Background(), nil, path.Root("conn"), &diags) != nil || !diags.HasError() {
+		t.Errorf("Expected an error connecting with an unknown host")
+	}
+	// Conn blocks go through the provider jump hosts and proxy, unknown too
+	diags = nil
+	if clients.Get(context.Background(), _connection("localhost:22", "secret"), path.Root("conn"), &diags) != nil || !diags.HasError() {
+		t.Errorf("Expected an error connecting with an unknown provider configuration")
+	}
+}
+
+func TestRemoteClientsSetupInterrupted(t *testing.T) {
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 { return 0 })
+	started := filepath.Join(t.TempDir(), "started")
+	command, _ := types.ListValueFrom(context.Background(), types.StringType,
+		_script(fmt.Sprintf(`if [ ! -e %[1]s ]; then touch %[1]s; exec sleep 5; fi; echo '{"password": "secret"}'`, started)))
+	clients := _remoteClients()
+	clients.config.Host = types.StringValue(host)
+	clients.config.Username = types.StringValue("root")
+	clients.config.CredentialCommand = command
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var diags diag.Diagnostics
+	if clients.Get(ctx, nil, path.Root("conn"), &diags) != nil || !diags.HasError() {
+		t.Fatalf("Expected the credential command to time out: %v", diags)
+	}
+	// The next resource doesn't get the error of the first one
+	diags = nil
+	client := clients.Get(context.Background(), nil, path.Root("conn"), &diags)
+	if diags.HasError() {
+		t.Fatalf("Couldn't connect: %v", diags)
+	}
+	client.Close()
+}
@@ -0,0 +1,196 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TransportSCP transfers file contents with the legacy SCP protocol, running
+// shell commands for the other file operations.
+const TransportSCP = "scp"
+
+// scpMode is the mode of the files created by SCP, restricted by the umask
+// like `tee` creates them.
+const scpMode = "0666"
+
+// useSCP tells whether file contents are transferred with SCP: with the scp
+// transport, or with the auto transport if the server has no sftp subsystem
+// but has scp.
+func (c *RemoteClient) useSCP(ctx context.Context) (bool, error) {
+	switch c.transport {
+	case TransportSCP:
+		return true, nil
+	case TransportAuto:
+		if !c.sftpMissing() {
+			return false, nil
+		}
+		return c.scpAvailable(ctx)
+	default:
+		return false, nil
+	}
+}
+
+// scpAvailable probes once whether scp runs on the server.
+func (c *RemoteClient) scpAvailable(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	probed, available := c.scpProbed, c.hasSCP
+	c.mu.Unlock()
+	if probed {
+		return available, nil
+	}
+
+	_, err := c.output(ctx, true, false, "command -v scp")
+	if err != nil && !exited(err) {
+		return false, err
+	}
+	c.mu.Lock()
+	c.scpProbed, c.hasSCP = true, err == nil
+	c.mu.Unlock()
+	return err == nil, nil
+}
+
+// writeFileSCP runs `scp -t` to receive content into p. The whole exchange is
+// sent at once, as the sink reads it in order, and its acknowledgments are
+// checked afterwards.
+func (c *RemoteClient) writeFileSCP(ctx context.Context, content string, p string, sudo bool, ensureDir bool) error {
+	if ensureDir {
+		if err := c.CreateDir(ctx, path.Dir(p), sudo); err != nil {
+			return err
+		}
+	}
+
+	var stdin bytes.Buffer
+	fmt.Fprintf(&stdin, "C%s %d %s\n", scpMode, len(content), path.Base(p))
+	stdin.WriteString(content)
+	stdin.WriteByte(0)
+
+	var stdout bytes.Buffer
+	// The whole content is written again, so retrying is safe
+	err := c.exec(ctx, true, func(session *ssh.Session) error {
+		stdout.Reset()
+		return c.runCommand(session, fmt.Sprintf("scp -t %s", p), sudo, stdin.Bytes(), &stdout)
+	})
+	if interrupted(err) != nil {
+		return err
+	}
+
+	// The sink acknowledges its start, the file record and the content
+	reply := bufio.NewReader(&stdout)
+	var ackErr error
+	for i := 0; i < 3 && ackErr == nil; i++ {
+		ackErr = readSCPAck(reply)
+	}
+	return scpResult(ackErr, err)
+}
+
+// readFileSCP runs `scp -f` to send the content of p. Like writeFileSCP, the
+// acknowledgments of the client are all sent at once.
+func (c *RemoteClient) readFileSCP(ctx context.Context, p string, sudo bool) (string, bool, error) {
+	var stdout bytes.Buffer
+	err := c.exec(ctx, true, func(session *ssh.Session) error {
+		stdout.Reset()
+		return c.runCommand(session, fmt.Sprintf("scp -f %s", p), sudo, []byte{0, 0, 0}, &stdout)
+	})
+	if interrupted(err) != nil {
+		return "", false, err
+	}
+
+	content, readErr := readSCPFile(bufio.NewReader(&stdout))
+	var remoteErr scpError
+	if errors.As(readErr, &remoteErr) && strings.Contains(string(remoteErr), "No such file or directory") {
+		return "", false, nil
+	}
+	if err := scpResult(readErr, err); err != nil {
+		return "", false, err
+	}
+	return content, true, nil
+}
+
+// scpError is an error reported by the remote end of SCP, e.g.
+// `scp: /etc/hosts: Permission denied`.
+type scpError string
+
+func (e scpError) Error() string {
+	return string(e)
+}
+
+// scpResult returns the error of an SCP exchange: the error reported by the
+// remote end if any, else the error of the command, which explains a broken
+// exchange better, e.g. when scp is missing.
+func scpResult(protocolErr error, cmdErr error) error {
+	var remoteErr scpError
+	if errors.As(protocolErr, &remoteErr) || cmdErr == nil {
+		return protocolErr
+	}
+	return cmdErr
+}
+
+// readSCPAck reads an acknowledgment, returning the error the remote end
+// reported, if any.
+func readSCPAck(reply *bufio.Reader) error {
+	code, err := reply.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp: missing acknowledgment: %w", err)
+	}
+	if code == 0 {
+		return nil
+	}
+	message, _ := reply.ReadString('\n')
+	if code != 1 && code != 2 {
+		return fmt.Errorf("scp: unexpected reply %q", string(code)+message)
+	}
+	return scpError(strings.TrimRight(message, "\n"))
+}
+
+// readSCPFile reads the record and the content of the file sent by an SCP
+// source, skipping the times records.
+func readSCPFile(reply *bufio.Reader) (string, error) {
+	for {
+		code, err := reply.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("scp: no file received: %w", err)
+		}
+		if code != 'C' && code != 'T' {
+			reply.UnreadByte()
+			if err := readSCPAck(reply); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		record, err := reply.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("scp: truncated record: %w", err)
+		}
+		if code == 'T' {
+			continue
+		}
+
+		// C<mode> <size> <name>
+		fields := strings.SplitN(strings.TrimRight(record, "\n"), " ", 3)
+		if len(fields) != 3 {
+			return "", fmt.Errorf("scp: unexpected record %q", record)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return "", fmt.Errorf("scp: unexpected file size in record %q", record)
+		}
+		content := make([]byte, size)
+		if _, err := io.ReadFull(reply, content); err != nil {
+			return "", fmt.Errorf("scp: truncated content: %w", err)
+		}
+		if err := readSCPAck(reply); err != nil {
+			return "", err
+		}
+		return string(content), nil
+	}
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// _scpFiles emulates the sink and the source of `scp` on in-memory files.
+type _scpFiles struct {
+	mu       sync.Mutex
+	files    map[string]string
+	commands []string
+}
+
+func (f *_scpFiles) handle(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, cmd)
+	input := bufio.NewReader(stdin)
+
+	switch {
+	case cmd == "command -v scp":
+		return 0
+	case strings.HasPrefix(cmd, "scp -t "):
+		stdout.Write([]byte{0})
+		record, _ := input.ReadString('\n')
+		var mode string
+		var size int
+		if _, err := fmt.Sscanf(record, "C%s %d", &mode, &size); err != nil {
+			fmt.Fprintf(stdout, "\x01scp: protocol error: %s\n", record)
+			return 1
+		}
+		stdout.Write([]byte{0})
+		content := make([]byte, size)
+		io.ReadFull(input, content)
+		input.ReadByte()
+		f.files[strings.TrimPrefix(cmd, "scp -t ")] = string(content)
+		stdout.Write([]byte{0})
+		return 0
+	case strings.HasPrefix(cmd, "scp -f "):
+		input.ReadByte()
+		path := strings.TrimPrefix(cmd, "scp -f ")
+		content, ok := f.files[path]
+		if !ok {
+			fmt.Fprintf(stdout, "\x01scp: %s: No such file or directory\n", path)
+			return 1
+		}
+		fmt.Fprintf(stdout, "C0644 %d file\n", len(content))
+		input.ReadByte()
+		fmt.Fprint(stdout, content)
+		stdout.Write([]byte{0})
+		input.ReadByte()
+		return 0
+	default:
+		fmt.Fprintf(stderr, "sh: %s: not found\n", cmd)
+		return 127
+	}
+}
+
+func TestSCPTransfers(t *testing.T) {
+	files := &_scpFiles{files: map[string]string{}}
+	host, _ := _execServer(t, files.handle)
+	client := _sftpClient(t, host, TransportSCP)
+	ctx := context.Background()
+
+	if err := client.WriteFile(ctx, "line\n\x00binary", "/etc/file", false, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	files.mu.Lock()
+	written := files.files["/etc/file"]
+	files.mu.Unlock()
+	if written != "line\n\x00binary" {
+		t.Errorf("Unexpected content written: %q", written)
+	}
+	content, exists, err := client.ReadFile(ctx, "/etc/file", false)
+	if err != nil || !exists || content != "line\n\x00binary" {
+		t.Errorf("Unexpected read: %q, %t, %v", content, exists, err)
+	}
+	if _, exists, err := client.ReadFile(ctx, "/etc/missing", false); err != nil || exists {
+		t.Errorf("Unexpected read of a missing file: %t, %v", exists, err)
+	}
+}
+
+func TestSCPErrors(t *testing.T) {
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		if strings.HasPrefix(cmd, "scp -t ") {
+			fmt.Fprint(stdout, "\x00\x01scp: /etc/file: Permission denied\n")
+			return 1
+		}
+		fmt.Fprint(stderr, "sh: scp: not found\n")
+		return 127
+	})
+	client := _sftpClient(t, host, TransportSCP)
+
+	err := client.WriteFile(context.Background(), "content", "/etc/file", false, false)
+	if err == nil || err.Error() != "scp: /etc/file: Permission denied" {
+		t.Errorf("Unexpected write error: %v", err)
+	}
+	// Without reply, the error of the command explains the failure
+	_, _, err = client.ReadFile(context.Background(), "/etc/file", false)
+	if err == nil || !strings.Contains(err.Error(), "scp: not found") {
+		t.Errorf("Unexpected read error: %v", err)
+	}
+}
+
+func TestSCPAutoProbes(t *testing.T) {
+	files := &_scpFiles{files: map[string]string{}}
+	host, _ := _execServer(t, files.handle)
+	client := _sftpClient(t, host, TransportAuto)
+
+	for i := 0; i < 2; i++ {
+		if err := client.WriteFile(context.Background(), "content", "/etc/file", false, false); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+	}
+	// The server has no sftp subsystem, and scp is probed once
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	expected := []string{"command -v scp", "scp -t /etc/file", "scp -t /etc/file"}
+	if fmt.Sprint(files.commands) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected commands: %q", files.commands)
+	}
+}
@@ -0,0 +1,235 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Transports of file operations.
+const (
+	TransportShell = "shell"
+	TransportSFTP  = "sftp"
+	TransportAuto  = "auto"
+)
+
+// errNoSFTP reports a server without the sftp subsystem.
+var errNoSFTP = errors.New("the remote server has no sftp subsystem")
+
+// trySFTP runs fn with a SFTP client over a new session, if the transport runs
+// file operations with SFTP. done is false if the operation must run otherwise:
+// with the shell and scp transports, with sudo as SFTP can't escalate
+// privileges, or with the auto transport if the server has no sftp subsystem.
+// op describes the operation for errors, e.g. `write /etc/hosts`.
+func (c *RemoteClient) trySFTP(ctx context.Context, sudo bool, retry bool, op string, fn func(client *sftp.Client) error) (done bool, err error) {
+	if sudo || (c.transport != TransportSFTP && c.transport != TransportAuto) {
+		return false, nil
+	}
+	if c.transport == TransportAuto && c.sftpMissing() {
+		return false, nil
+	}
+
+	err = c.exec(ctx, retry, func(session *ssh.Session) error {
+		client, err := newSFTPClient(session)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		return fn(client)
+	})
+	if errors.Is(err, errNoSFTP) {
+		if c.transport == TransportAuto {
+			c.mu.Lock()
+			c.noSFTP = true
+			c.mu.Unlock()
+			return false, nil
+		}
+		return true, err
+	}
+	if err != nil && interrupted(err) == nil {
+		err = fmt.Errorf("sftp %s: %w", op, err)
+	}
+	return true, err
+}
+
+func (c *RemoteClient) sftpMissing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.noSFTP
+}
+
+// newSFTPClient starts the sftp subsystem in session.
+func newSFTPClient(session *ssh.Session) (*sftp.Client, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", errNoSFTP, err.Error())
+	}
+	return sftp.NewClientPipe(stdout, stdin)
+}
+
+// sftpPath resolves p against the working directory, SFTP paths being
+// relative to the home directory otherwise.
+func (c *RemoteClient) sftpPath(p string) string {
+	if c.env.WorkingDir == "" || path.IsAbs(p) {
+		return p
+	}
+	return path.Join(c.env.WorkingDir, p)
+}
+
+// applyUmask sets the permissions of p, just created with the default perm of
+// its kind, to what the umask of the environment allows. The SFTP server
+// applies its own umask otherwise.
+func (c *RemoteClient) applyUmask(client *sftp.Client, p string, perm uint32) error {
+	if c.env.Umask == "" {
+		return nil
+	}
+	umask, err := strconv.ParseUint(c.env.Umask, 8, 32)
+	if err != nil {
+		return err
+	}
+	return client.Chmod(p, fileMode(perm&^uint32(umask)))
+}
+
+func (c *RemoteClient) writeFileSFTP(client *sftp.Client, content string, p string, ensureDir bool) error {
+	p = c.sftpPath(p)
+	if ensureDir {
+		if err := client.MkdirAll(path.Dir(p)); err != nil {
+			return err
+		}
+	}
+
+	_, statErr := client.Stat(p)
+	file, err := client.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := file.ReadFrom(strings.NewReader(content)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		return c.applyUmask(client, p, 0666)
+	}
+	return nil
+}
+
+func (c *RemoteClient) readFileSFTP(client *sftp.Client, p string) (string, bool, error) {
+	file, err := client.Open(c.sftpPath(p))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	var content bytes.Buffer
+	if _, err := file.WriteTo(&content); err != nil {
+		return "", false, err
+	}
+	return content.String(), true, nil
+}
+
+func (c *RemoteClient) createDirSFTP(client *sftp.Client, p string) error {
+	p = c.sftpPath(p)
+	_, statErr := client.Stat(p)
+	if err := client.MkdirAll(p); err != nil {
+		return err
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		return c.applyUmask(client, p, 0777)
+	}
+	return nil
+}
+
+// chownSFTP changes the owner of p if uid isn't negative, its group if gid
+// isn't.
+func (c *RemoteClient) chownSFTP(client *sftp.Client, p string, uid int, gid int) error {
+	p = c.sftpPath(p)
+	stat, err := statSFTP(client, p)
+	if err != nil {
+		return err
+	}
+	if uid < 0 {
+		uid = int(stat.UID)
+	}
+	if gid < 0 {
+		gid = int(stat.GID)
+	}
+	return client.Chown(p, uid, gid)
+}
+
+func (c *RemoteClient) renameSFTP(client *sftp.Client, from string, to string) error {
+	from, to = c.sftpPath(from), c.sftpPath(to)
+	// Plain SFTP renames fail if the target exists, unlike `mv`
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(from, to)
+	}
+	return client.Rename(from, to)
+}
+
+func statSFTP(client *sftp.Client, p string) (*sftp.FileStat, error) {
+	info, err := client.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	stat, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return nil, fmt.Errorf("no file attributes for %s", p)
+	}
+	return stat, nil
+}
+
+// sftpStatChars are the `stat -c` format characters known over SFTP. Owner and
+// group names aren't part of SFTP attributes.
+const sftpStatChars = "aug"
+
+// statValue formats the attribute of stat that `stat -c %<char>` prints, char
+// being one of sftpStatChars.
+func statValue(stat *sftp.FileStat, char string) string {
+	switch char {
+	case "a":
+		return strconv.FormatUint(uint64(stat.Mode&07777), 8)
+	case "u":
+		return strconv.FormatUint(uint64(stat.UID), 10)
+	default:
+		return strconv.FormatUint(uint64(stat.GID), 10)
+	}
+}
+
+// fileMode converts POSIX permission bits to an os.FileMode.
+func fileMode(perm uint32) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if perm&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if perm&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
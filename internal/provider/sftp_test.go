@@ -0,0 +1,166 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// _sftpClient connects to host with transport.
+func _sftpClient(t *testing.T, host string, transport string) *RemoteClient {
+	client := _remoteClient(t, host)
+	client.transport = transport
+	return client
+}
+
+// _failingHandler fails the test on any command, for operations expected to
+// run over SFTP.
+func _failingHandler(t *testing.T) _execHandler {
+	return func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		t.Errorf("Unexpected command: %s", cmd)
+		return 1
+	}
+}
+
+func TestSFTPFileOperations(t *testing.T) {
+	host, _ := _sftpServer(t, _failingHandler(t))
+	client := _sftpClient(t, host, TransportSFTP)
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sub", "file")
+
+	if err := client.WriteFile(ctx, "content", file, false, true); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if err := client.ChmodFile(ctx, file, "0640", false); err != nil {
+		t.Fatalf("Chmod failed: %s", err)
+	}
+	content, exists, err := client.ReadFile(ctx, file, false)
+	if err != nil || !exists || content != "content" {
+		t.Errorf("Unexpected read: %q, %t, %v", content, exists, err)
+	}
+	if permissions, err := client.ReadFilePermissions(ctx, file, false); err != nil || permissions != "0640" {
+		t.Errorf("Unexpected permissions: %q, %v", permissions, err)
+	}
+	if owner, err := client.ReadFileOwner(ctx, file, false); err != nil || owner != strconv.Itoa(os.Getuid()) {
+		t.Errorf("Unexpected owner: %q, %v", owner, err)
+	}
+	if exists, err := client.FileExists(ctx, file, false); err != nil || !exists {
+		t.Errorf("Unexpected file existence: %t, %v", exists, err)
+	}
+	if exists, err := client.dirExists(ctx, file, false); err != nil || exists {
+		t.Errorf("Unexpected folder existence: %t, %v", exists, err)
+	}
+
+	renamed := filepath.Join(dir, "renamed")
+	if err := client.RenameFile(ctx, file, renamed, false); err != nil {
+		t.Fatalf("Rename failed: %s", err)
+	}
+	if _, exists, err := client.ReadFile(ctx, file, false); err != nil || exists {
+		t.Errorf("Renamed file still exists: %t, %v", exists, err)
+	}
+	if err := client.DeleteFile(ctx, renamed, false); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if _, err := os.Stat(renamed); !os.IsNotExist(err) {
+		t.Errorf("Deleted file still exists: %v", err)
+	}
+
+	if err := client.DeleteFolder(ctx, filepath.Join(dir, "sub"), false); err != nil {
+		t.Fatalf("Folder deletion failed: %s", err)
+	}
+	if exists, err := client.dirExists(ctx, filepath.Join(dir, "sub"), false); err != nil || exists {
+		t.Errorf("Deleted folder still exists: %t, %v", exists, err)
+	}
+}
+
+func TestSFTPUmask(t *testing.T) {
+	host, _ := _sftpServer(t, _failingHandler(t))
+	client := _sftpClient(t, host, TransportSFTP).WithEnv(CommandEnv{Umask: "027"})
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "dir")
+
+	if err := client.CreateDir(ctx, dir, false); err != nil {
+		t.Fatalf("Mkdir failed: %s", err)
+	}
+	if err := client.WriteFile(ctx, "content", filepath.Join(dir, "file"), false, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	for path, expected := range map[string]os.FileMode{dir: 0750, filepath.Join(dir, "file"): 0640} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != expected {
+			t.Errorf("Unexpected permissions of %s: %o", path, info.Mode().Perm())
+		}
+	}
+}
+
+func TestSFTPSudoRunsShell(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	host, _ := _sftpServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		// Like the commands, read the content of the write to the end
+		io.Copy(io.Discard, stdin)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, cmd)
+		return 0
+	})
+	client := _sftpClient(t, host, TransportSFTP)
+
+	// SFTP can't escalate privileges, nor resolve user names
+	if err := client.WriteFile(context.Background(), "content", "/etc/file", true, false); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if err := client.ChownFile(context.Background(), "/etc/file", "root", false); err != nil {
+		t.Fatalf("Chown failed: %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || !strings.Contains(received[0], "cat /dev/stdin") || received[1] != "chown root /etc/file" {
+		t.Errorf("Unexpected commands: %q", received)
+	}
+}
+
+func TestSFTPAutoFallsBack(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, cmd)
+		return 0
+	})
+	client := _sftpClient(t, host, TransportAuto)
+
+	for i := 0; i < 2; i++ {
+		if err := client.ChmodFile(context.Background(), "/tmp/file", "0644", false); err != nil {
+			t.Fatalf("Chmod failed: %s", err)
+		}
+	}
+	if !client.sftpMissing() {
+		t.Errorf("The missing subsystem wasn't remembered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0] != "chmod 0644 /tmp/file" {
+		t.Errorf("Unexpected commands: %q", received)
+	}
+}
+
+func TestSFTPMissingSubsystem(t *testing.T) {
+	host, _ := _execServer(t, _failingHandler(t))
+	client := _sftpClient(t, host, TransportSFTP)
+
+	err := client.ChmodFile(context.Background(), "/tmp/file", "0644", false)
+	if err == nil || !strings.Contains(err.Error(), "no sftp subsystem") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// shellLoop is the script of the helper shells. Requests are framed by their
+// number of lines and the length of their stdin, the lines being read with
+// `read` as it doesn't read ahead unlike the shell reading its script. stdin
+// is read exactly by GNU head, or one byte at a time by dd elsewhere, and
+// whatever the command leaves of it is drained. Each request runs in a
+// subshell, and its exit status, stdout length and stderr length are sent,
+// followed by stdout and stderr. %s is the marker of the readiness of the
+// shell.
+const shellLoop = `t=$(mktemp -d) || exit 1
+trap 'rm -rf "$t"' EXIT
+trap 'exit 1' HUP INT TERM
+if head --version 2>/dev/null | grep -q 'GNU coreutils'; then
+  r() { head -c "$1"; }
+else
+  r() { dd bs=1 count="$1" 2>/dev/null; }
+fi
+echo %s
+while IFS=' ' read -r n m; do
+  s=
+  while [ "$n" -gt 0 ]; do
+    IFS= read -r l || exit 1
+    s="$s$l
+"
+    n=$((n - 1))
+  done
+  if [ "$m" -gt 0 ]; then
+    r "$m" | { (eval "$s") >"$t/o" 2>"$t/e"; c=$?; cat >/dev/null; exit $c; }
+  else
+    (eval "$s") </dev/null >"$t/o" 2>"$t/e"
+  fi
+  c=$?
+  printf '%%s %%s %%s\n' "$c" $(wc -c <"$t/o") $(wc -c <"$t/e")
+  cat "$t/o" "$t/e"
+done`
+
+// errNoShell reports a server where helper shells can't run.
+var errNoShell = errors.New("couldn't start a helper shell")
+
+// exitStatusError reports a command of a helper shell that exited with a
+// non-zero status, like ssh.ExitError for commands run in a session.
+type exitStatusError struct {
+	status int
+}
+
+func (e *exitStatusError) Error() string {
+	return fmt.Sprintf("Process exited with status %d", e.status)
+}
+
+// exited tells whether err is due to a command that exited with a non-zero
+// status, rather than to the connection.
+func exited(err error) bool {
+	var exitErr *ssh.ExitError
+	var statusErr *exitStatusError
+	return errors.As(err, &exitErr) || errors.As(err, &statusErr)
+}
+
+// remoteShell is a helper shell running the commands it's sent one at a time,
+// saving the opening of a session per command.
+type remoteShell struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+
+	// broken is set once the state of the shell is unknown, after an error.
+	broken bool
+}
+
+// startShell starts a helper shell in session.
+func startShell(ctx context.Context, session *ssh.Session) (*remoteShell, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	shell := &remoteShell{session: session, stdin: stdin, stdout: bufio.NewReader(stdout)}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ready := "SHELL-READY-" + hex.EncodeToString(nonce)
+	if err := session.Start("sh -c " + shellQuote(fmt.Sprintf(shellLoop, ready))); err != nil {
+		return nil, err
+	}
+
+	err = shell.watch(ctx, func() error {
+		// Startup files of the login shell may print first
+		for {
+			line, err := shell.stdout.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("%w: %s", errNoShell, err.Error())
+			}
+			if strings.TrimRight(line, "\r\n") == ready {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shell, nil
+}
+
+// run sends script and its stdin to the shell, copying its output to stdout
+// and stderr, and returns its exit status.
+func (s *remoteShell) run(ctx context.Context, script string, stdin []byte, stdout io.Writer, stderr io.Writer) (int, error) {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	var status int
+	err := s.watch(ctx, func() error {
+		request := fmt.Sprintf("%d %d\n%s\n", strings.Count(script, "\n")+1, len(stdin), script)
+		if _, err := io.WriteString(s.stdin, request); err != nil {
+			return err
+		}
+		if _, err := s.stdin.Write(stdin); err != nil {
+			return err
+		}
+
+		header, err := s.stdout.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		var outLength, errLength int64
+		if _, err := fmt.Sscanf(header, "%d %d %d\n", &status, &outLength, &errLength); err != nil {
+			return fmt.Errorf("unexpected reply of the helper shell %q", header)
+		}
+		if _, err := io.CopyN(stdout, s.stdout, outLength); err != nil {
+			return err
+		}
+		_, err = io.CopyN(stderr, s.stdout, errLength)
+		return err
+	})
+	return status, err
+}
+
+// watch runs fn, closing the session if ctx is done first. The shell is broken
+// if fn fails.
+func (s *remoteShell) watch(ctx context.Context, fn func() error) error {
+	finished := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			s.session.Signal(ssh.SIGTERM)
+			s.session.Close()
+		case <-finished:
+		}
+	}()
+	err := fn()
+	close(finished)
+	<-stopped
+
+	if err != nil {
+		s.broken = true
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// runShell runs cmd, fed with stdin, in a helper shell if they're enabled and
+// cmd isn't escalated. done is false if cmd must run in a session instead.
+// Escalated commands always do, so that what they read isn't spooled to the
+// temporary files of the shell. Shells are kept by the session pool of their
+// connection, and reused by the following commands.
+func (c *RemoteClient) runShell(ctx context.Context, retry bool, cmd string, sudo bool, stdin []byte, stdout io.Writer) (done bool, err error) {
+	if !c.persistentShell || c.shellMissing() || sudo {
+		return false, nil
+	}
+
+	if c.commandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.commandTimeout)
+		defer cancel()
+	}
+
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return true, err
+	}
+	shell, err := conn.sessionPool.GetShell(ctx)
+	if err != nil {
+		c.release(conn)
+		switch {
+		case ctx.Err() != nil:
+			return true, ctx.Err()
+		case errors.Is(err, errNoShell):
+			c.mu.Lock()
+			c.noShell = true
+			c.mu.Unlock()
+			return false, nil
+		case c.isLost(conn):
+			// Sessions reconnect
+			return false, nil
+		}
+		return true, err
+	}
+
+	var stderr bytes.Buffer
+	status, err := shell.run(ctx, c.env.wrap(c.env.script(nil, cmd, false)), stdin, stdout, &stderr)
+	conn.sessionPool.PutShell(shell)
+	c.release(conn)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return true, Error{cmd: cmd, err: ctx.Err(), stderr: stderr.Bytes()}
+	case err != nil && retry:
+		// The command runs again in a session, reconnecting if needed
+		return false, nil
+	case err != nil:
+		return true, fmt.Errorf("helper shell lost, the command may or may not have completed: %s", err.Error())
+	case status != 0:
+		return true, Error{cmd: cmd, err: &exitStatusError{status}, stderr: stderr.Bytes()}
+	}
+	return true, nil
+}
+
+func (c *RemoteClient) shellMissing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.noShell
+}
@@ -0,0 +1,187 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// _shellClient connects to host with helper shells enabled.
+func _shellClient(t *testing.T, host string) *RemoteClient {
+	client := _remoteClient(t, host)
+	client.persistentShell = true
+	return client
+}
+
+// _helperShells counts the helper shells started among commands.
+func _helperShells(commands []string) int {
+	count := 0
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd, "sh -c 't=$(mktemp -d)") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPersistentShell(t *testing.T) {
+	host, commands := _shellServer(t)
+	client := _shellClient(t, host)
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("line\n\x00binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.ChmodFile(ctx, file, "0600", false); err != nil {
+		t.Fatalf("Chmod failed: %s", err)
+	}
+	content, exists, err := client.ReadFile(ctx, file, false)
+	if err != nil || !exists || content != "line\n\x00binary" {
+		t.Errorf("Unexpected read: %q, %t, %v", content, exists, err)
+	}
+	if permissions, err := client.ReadFilePermissions(ctx, file, false); err != nil || permissions != "0600" {
+		t.Errorf("Unexpected permissions: %q, %v", permissions, err)
+	}
+	// Failing commands report their status and stderr
+	if _, exists, err := client.ReadFile(ctx, file+".missing", false); err != nil || exists {
+		t.Errorf("Unexpected read of a missing file: %t, %v", exists, err)
+	}
+	_, err = client.output(ctx, true, false, "echo failed >&2; exit 3")
+	var cmdErr Error
+	if !errors.As(err, &cmdErr) || !exited(err) || err.Error() != "`echo failed >&2; exit 3`\n  Process exited with status 3\n  failed" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// One helper shell ran every command
+	if got := commands(); len(got) != 1 || _helperShells(got) != 1 {
+		t.Errorf("Unexpected commands: %q", got)
+	}
+}
+
+func TestPersistentShellEnv(t *testing.T) {
+	host, _ := _shellServer(t)
+	client := _shellClient(t, host)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	output, err := client.WithEnv(CommandEnv{WorkingDir: dir, Environment: map[string]string{"GREETING": "it's me"}}).
+		output(ctx, true, false, `pwd; echo "$GREETING"`)
+	if err != nil || string(output) != dir+"\nit's me\n" {
+		t.Errorf("Unexpected output: %q, %v", output, err)
+	}
+	// The settings don't outlive the command
+	output, err = client.output(ctx, true, false, `pwd; echo "$GREETING"`)
+	if err != nil || strings.HasPrefix(string(output), dir) || strings.Contains(string(output), "it's me") {
+		t.Errorf("Unexpected output: %q, %v", output, err)
+	}
+}
+
+func TestPersistentShellStdin(t *testing.T) {
+	host, commands := _shellServer(t)
+	client := _shellClient(t, host)
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "file")
+
+	for _, content := range []string{"line\n\x00binary", "", strings.Repeat("x", 100000)} {
+		if err := client.WriteFile(ctx, content, file, false, false); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		if written, err := os.ReadFile(file); err != nil || string(written) != content {
+			t.Errorf("Unexpected content: %d bytes, %v", len(written), err)
+		}
+	}
+	// stdin left unread doesn't reach the shell
+	if done, err := client.runShell(ctx, true, "true", false, []byte("echo leftover\n"), nil); !done || err != nil {
+		t.Fatalf("Unexpected run: %t, %v", done, err)
+	}
+	if output, err := client.output(ctx, true, false, "echo ok"); err != nil || string(output) != "ok\n" {
+		t.Errorf("Unexpected output: %q, %v", output, err)
+	}
+
+	if got := commands(); len(got) != 1 || _helperShells(got) != 1 {
+		t.Errorf("Unexpected commands: %q", got)
+	}
+}
+
+func TestPersistentShellSessions(t *testing.T) {
+	handler, commands := _shellHandler(t)
+	// sudo is left to the local shell
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		return handler(strings.TrimPrefix(cmd, "sudo -n -u 'root' -- "), stdin, stdout, stderr)
+	})
+	client, err := NewRemoteClientWithDialer(context.Background(), &Dialer{Host: host, ClientConfig: _passwordConfig("secret")}, nil, 1)
+	if err != nil {
+		t.Fatalf("Couldn't connect: %s", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.persistentShell = true
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "file")
+
+	// The idle shell gives its only slot to the session of the write with
+	// sudo
+	for i := 0; i < 2; i++ {
+		if exists, err := client.FileExists(ctx, file, false); err != nil || exists != (i > 0) {
+			t.Errorf("Unexpected file existence: %t, %v", exists, err)
+		}
+		if err := client.WriteFile(ctx, "content", file, true, false); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+	}
+	got := commands()
+	if len(got) != 4 || _helperShells(got) != 2 || got[1] != "sh -c "+shellQuote("cat /dev/stdin | tee "+file) {
+		t.Errorf("Unexpected commands: %q", got)
+	}
+}
+
+func TestPersistentShellTimeout(t *testing.T) {
+	host, commands := _shellServer(t)
+	client := _shellClient(t, host)
+	client.commandTimeout = 200 * time.Millisecond
+
+	_, err := client.output(context.Background(), true, false, "sleep 5")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The interrupted shell is replaced
+	if output, err := client.output(context.Background(), true, false, "echo ok"); err != nil || string(output) != "ok\n" {
+		t.Errorf("Unexpected output: %q, %v", output, err)
+	}
+	if got := commands(); _helperShells(got) != 2 {
+		t.Errorf("Unexpected commands: %q", got)
+	}
+}
+
+func TestPersistentShellMissing(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	host, _ := _execServer(t, func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, cmd)
+		if strings.HasPrefix(cmd, "sh -c ") {
+			return 127
+		}
+		return 0
+	})
+	client := _shellClient(t, host)
+
+	for i := 0; i < 2; i++ {
+		if err := client.ChmodFile(context.Background(), "/tmp/file", "0644", false); err != nil {
+			t.Fatalf("Chmod failed: %s", err)
+		}
+	}
+	// Commands run in sessions once the shell failed to start
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 3 || _helperShells(received) != 1 || received[2] != "chmod 0644 /tmp/file" {
+		t.Errorf("Unexpected commands: %q", received)
+	}
+}
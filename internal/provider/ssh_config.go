@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// SSHConfigHost holds the connection settings of a host alias, as resolved
+// from an OpenSSH client config file.
+type SSHConfigHost struct {
+	HostName              string
+	Port                  string
+	User                  string
+	IdentityFiles         []string
+	ProxyJump             []string
+	StrictHostKeyChecking string
+}
+
+// Address returns the `host:port` address to dial.
+func (h *SSHConfigHost) Address() string {
+	port := h.Port
+	if port == "" {
+		port = "22"
+	}
+	return net.JoinHostPort(h.HostName, port)
+}
+
+// SSHConfig is a parsed OpenSSH client config file.
+type SSHConfig struct {
+	config *ssh_config.Config
+}
+
+// LoadSSHConfig parses the OpenSSH client config file at path.
+func LoadSSHConfig(path string) (*SSHConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, err := ssh_config.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return &SSHConfig{config: config}, nil
+}
+
+// Host resolves the settings of alias. Settings missing from the file are left
+// empty, except HostName which defaults to alias.
+func (c *SSHConfig) Host(alias string) (host *SSHConfigHost, err error) {
+	defer func() {
+		// ssh_config panics on Match blocks instead of returning an error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("couldn't resolve %s: %v", alias, r)
+		}
+	}()
+
+	host = &SSHConfigHost{}
+	values := map[string]*string{
+		"HostName":              &host.HostName,
+		"Port":                  &host.Port,
+		"User":                  &host.User,
+		"StrictHostKeyChecking": &host.StrictHostKeyChecking,
+	}
+	for key, value := range values {
+		if *value, err = c.config.Get(alias, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if host.HostName == "" {
+		host.HostName = alias
+	}
+	host.HostName = expandSSHConfigTokens(host.HostName, alias, host.User)
+
+	identityFiles, err := c.config.GetAll(alias, "IdentityFile")
+	if err != nil {
+		return nil, err
+	}
+	for _, identityFile := range identityFiles {
+		host.IdentityFiles = append(host.IdentityFiles, expandPath(expandSSHConfigTokens(identityFile, host.HostName, host.User)))
+	}
+
+	proxyJump, err := c.config.Get(alias, "ProxyJump")
+	if err != nil {
+		return nil, err
+	}
+	if proxyJump != "" && !strings.EqualFold(proxyJump, "none") {
+		for _, jump := range strings.Split(proxyJump, ",") {
+			host.ProxyJump = append(host.ProxyJump, strings.TrimSpace(jump))
+		}
+	}
+
+	return host, nil
+}
+
+// HostKeyCheck maps StrictHostKeyChecking to a `host_key_check` mode. It
+// returns an empty string if the setting is missing.
+func (h *SSHConfigHost) HostKeyCheck() string {
+	switch strings.ToLower(h.StrictHostKeyChecking) {
+	case "":
+		return ""
+	case "no", "off":
+		return HostKeyCheckInsecure
+	case "accept-new":
+		return HostKeyCheckAcceptNew
+	default:
+		// `yes` and `ask`, as nobody can answer the prompt
+		return HostKeyCheckStrict
+	}
+}
+
+// ParseJump splits a ProxyJump `[user@]host[:port]` destination.
+func ParseJump(jump string) (user string, host string, port string) {
+	if i := strings.LastIndex(jump, "@"); i >= 0 {
+		user, jump = jump[:i], jump[i+1:]
+	}
+	host, port, err := net.SplitHostPort(jump)
+	if err != nil {
+		return user, jump, ""
+	}
+	return user, host, port
+}
+
+// expandSSHConfigTokens replaces the `%h`, `%r` and `%%` tokens.
+func expandSSHConfigTokens(value string, host string, user string) string {
+	return strings.NewReplacer("%%", "%", "%h", host, "%r", user).Replace(value)
+}
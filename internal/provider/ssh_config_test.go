@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+const _sshConfig = `
+Host web
+  HostName 10.0.0.12
+  User deploy
+  IdentityFile ~/.ssh/web_%r
+  ProxyJump admin@bastion:2222,gateway
+  StrictHostKeyChecking accept-new
+
+Host bastion
+  HostName bastion.example.com
+
+Host *
+  Port 8022
+  IdentityFile ~/.ssh/id_ed25519
+`
+
+func _loadSSHConfig(t *testing.T) *SSHConfig {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(_sshConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadSSHConfig(path)
+	if err != nil {
+		t.Fatalf("Couldn't load SSH config: %s", err)
+	}
+	return config
+}
+
+func TestSSHConfigHost(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	host, err := _loadSSHConfig(t).Host("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if host.Address() != "10.0.0.12:8022" {
+		t.Errorf("Unexpected address %s", host.Address())
+	}
+	if host.User != "deploy" {
+		t.Errorf("Unexpected user %s", host.User)
+	}
+	expectedIdentityFiles := []string{
+		filepath.Join(home, ".ssh/web_deploy"),
+		filepath.Join(home, ".ssh/id_ed25519"),
+	}
+	if !reflect.DeepEqual(host.IdentityFiles, expectedIdentityFiles) {
+		t.Errorf("Unexpected identity files %v", host.IdentityFiles)
+	}
+	if !reflect.DeepEqual(host.ProxyJump, []string{"admin@bastion:2222", "gateway"}) {
+		t.Errorf("Unexpected jumps %v", host.ProxyJump)
+	}
+	if host.HostKeyCheck() != HostKeyCheckAcceptNew {
+		t.Errorf("Unexpected host key check %s", host.HostKeyCheck())
+	}
+}
+
+func TestSSHConfigUnknownHost(t *testing.T) {
+	host, err := _loadSSHConfig(t).Host("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Address() != "localhost:8022" || host.User != "" || host.HostKeyCheck() != "" {
+		t.Errorf("Unexpected settings %+v", host)
+	}
+}
+
+func TestParseJump(t *testing.T) {
+	user, host, port := ParseJump("admin@bastion:2222")
+	if user != "admin" || host != "bastion" || port != "2222" {
+		t.Errorf("Unexpected jump %s %s %s", user, host, port)
+	}
+	user, host, port = ParseJump("gateway")
+	if user != "" || host != "gateway" || port != "" {
+		t.Errorf("Unexpected jump %s %s %s", user, host, port)
+	}
+}
+
+func TestApplySSHConfigPrecedence(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	configPath := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configPath, []byte(_sshConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(home, ".ssh/web_deploy")
+
+	for _, test := range []struct {
+		name     string
+		config   hashicupsProviderModel
+		username string
+		keyPath  string
+		jumpKey  bool
+	}{
+		{"ssh config only", hashicupsProviderModel{}, "deploy", identityFile, true},
+		{"username", hashicupsProviderModel{Username: types.StringValue("admin")}, "admin", identityFile, true},
+		{"private_key", hashicupsProviderModel{PrivateKey: types.StringValue("key")}, "deploy", "", false},
+		{"private_key_path", hashicupsProviderModel{PrivateKeyPath: types.StringValue("/keys/id")}, "deploy", "/keys/id", false},
+		{"private_key_env_var", hashicupsProviderModel{PrivateKeyEnvVar: types.StringValue("SSH_KEY")}, "deploy", "", false},
+		{"agent", hashicupsProviderModel{Agent: types.BoolValue(true)}, "deploy", "", false},
+		{"agent disabled", hashicupsProviderModel{Agent: types.BoolValue(false)}, "deploy", identityFile, true},
+	} {
+		config := test.config
+		config.Host = types.StringValue("web")
+		config.SSHConfigFile = types.StringValue(configPath)
+		var jumpHosts []hostModel
+		var diags diag.Diagnostics
+		(&hashicupsProvider{}).applySSHConfig(&config, &jumpHosts, &diags)
+		if diags.HasError() {
+			t.Fatalf("%s: %v", test.name, diags)
+		}
+
+		if config.Host.ValueString() != "10.0.0.12:8022" || config.Username.ValueString() != test.username ||
+			config.PrivateKeyPath.ValueString() != test.keyPath {
+			t.Errorf("%s: unexpected host %s, username %s, key path %q", test.name,
+				config.Host.ValueString(), config.Username.ValueString(), config.PrivateKeyPath.ValueString())
+		}
+		// The jump hosts use their own identity file only if the remote host
+		// would
+		if len(jumpHosts) != 2 {
+			t.Fatalf("%s: unexpected jump hosts %+v", test.name, jumpHosts)
+		}
+		jumpKeyPath := filepath.Join(home, ".ssh/id_ed25519")
+		if got := jumpHosts[0].PrivateKeyPath.ValueString(); (got == jumpKeyPath) != test.jumpKey {
+			t.Errorf("%s: unexpected jump host key path %q", test.name, got)
+		}
+		if !jumpHosts[0].PrivateKey.Equal(config.PrivateKey) && !test.jumpKey {
+			t.Errorf("%s: the jump host doesn't share the private key", test.name)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package provider
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// _privateKey generates a fresh ed25519 key.
+func _privateKey(t *testing.T) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+// _signer generates a fresh ed25519 signer.
+func _signer(t *testing.T) ssh.Signer {
+	signer, err := ssh.NewSignerFromKey(_privateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// _sshServer starts an in-process SSH server accepting connections on a
+// random local port until the end of the test, and returns its address.
+// Only `direct-tcpip` channels are served, so that it can act as a jump host;
+// it's meant to exercise dialing and authentication.
+func _sshServer(t *testing.T, config *ssh.ServerConfig) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sshServe(t, listener, config)
+	return listener.Addr().String()
+}
+
+// _sshServe serves SSH connections on listener until the end of the test.
+func _sshServe(t *testing.T, listener net.Listener, config *ssh.ServerConfig) {
+	config.AddHostKey(_signer(t))
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for channel := range channels {
+					if channel.ChannelType() != "direct-tcpip" {
+						channel.Reject(ssh.UnknownChannelType, "not supported")
+						continue
+					}
+					go _forward(channel)
+				}
+			}()
+		}
+	}()
+}
+
+// _forward serves a `direct-tcpip` channel by connecting to its destination.
+func _forward(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	io.Copy(conn, channel)
+	conn.Close()
+	channel.Close()
+}
+
+// _execHandler runs a command received by _execServer, and returns its exit
+// status.
+type _execHandler func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) uint32
+
+// _execServer starts an in-process SSH server accepting the password
+// `secret`, and running `exec` requests with handler. It returns the server
+// address, and a function dropping every open connection.
+func _execServer(t *testing.T, handler _execHandler) (string, func()) {
+	return _sessionServer(t, handler, false)
+}
+
+// _sftpServer starts a server like _execServer, also serving the local files
+// with the `sftp` subsystem.
+func _sftpServer(t *testing.T, handler _execHandler) (string, func()) {
+	return _sessionServer(t, handler, true)
+}
+
+func _sessionServer(t *testing.T, handler _execHandler, sftpEnabled bool) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if string(p) != "secret" {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(_signer(t))
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	drop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		conns = nil
+	}
+	t.Cleanup(drop)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for channel := range channels {
+					if channel.ChannelType() != "session" {
+						channel.Reject(ssh.UnknownChannelType, "not supported")
+						continue
+					}
+					go _exec(channel, handler, sftpEnabled)
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), drop
+}
+
+// _exec serves a `session` channel, running its `exec` request with handler,
+// or its `sftp` subsystem request if sftpEnabled.
+func _exec(newChannel ssh.NewChannel, handler _execHandler, sftpEnabled bool) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	for request := range requests {
+		if request.Type == "pty-req" {
+			request.Reply(true, nil)
+			continue
+		}
+		if request.Type == "subsystem" && sftpEnabled {
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(request.Payload, &payload); err != nil || payload.Name != "sftp" {
+				request.Reply(false, nil)
+				continue
+			}
+			request.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			return
+		}
+		if request.Type != "exec" {
+			request.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+			request.Reply(false, nil)
+			return
+		}
+		request.Reply(true, nil)
+
+		status := handler(payload.Command, channel, channel, channel.Stderr())
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}